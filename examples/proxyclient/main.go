@@ -8,12 +8,12 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 
 	"github.com/rancher/remotedialer-proxy/forward"
 	"github.com/rancher/remotedialer-proxy/proxyclient"
-	"github.com/rancher/wrangler/v3/pkg/generated/controllers/core"
 	"github.com/sirupsen/logrus"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/util/homedir"
@@ -153,7 +153,7 @@ func main() {
 		panic(err.Error())
 	}
 
-	coreFactory, err := core.NewFactoryFromConfigWithOptions(cfg, nil)
+	coreFactory, err := proxyclient.NewCoreFactory(cfg, namespace, certSecretName)
 	if err != nil {
 		logrus.Fatal(err)
 	}
@@ -166,6 +166,16 @@ func main() {
 		logrus.Fatal(err)
 	}
 
+	// The proxy relays connections to the port of the fake API
+	_, apiPort, err := net.SplitHostPort(fakeImperativeAPIAddr)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	forwardedPort, err := strconv.Atoi(apiPort)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
 	proxyClient, err := proxyclient.New(
 		ctx,
 		connectSecret,
@@ -174,6 +184,7 @@ func main() {
 		certServerName,
 		secretContoller,
 		portForwarder,
+		proxyclient.WithForwardedPorts(forwardedPort),
 	)
 	if err != nil {
 		logrus.Fatal(err)
@@ -183,7 +194,9 @@ func main() {
 		logrus.Fatal(err)
 	}
 
-	proxyClient.Run(ctx)
+	if err := proxyClient.Start(ctx); err != nil {
+		logrus.Fatal(err)
+	}
 
 	logrus.Info("RDP Client Started... Waiting for CTRL+C")
 	<-sigChan
@@ -191,4 +204,7 @@ func main() {
 
 	cancel()
 	proxyClient.Stop()
+	if err := proxyClient.Wait(); err != nil {
+		logrus.Error(err)
+	}
 }
@@ -0,0 +1,129 @@
+package forward
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	v1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	directServerPath  = "/connect"
+	directDialTimeout = 5 * time.Second
+)
+
+// Direct is a proxyclient.PortForwarder for clients running in the same
+// cluster as the proxy, which dial the proxy Service or Pod IPs directly rather
+// than through the port-forward API of the API server. It implements
+// proxyclient.ServerURLProvider, so that the client connects to the address it
+// picked.
+type Direct struct {
+	podClient     v1.PodController
+	namespace     string
+	serviceName   string
+	labelSelector string
+	port          int
+
+	mu   sync.Mutex
+	host string
+}
+
+// NewDirectToService returns a Direct dialing the ClusterIP Service
+// serviceName on port.
+func NewDirectToService(namespace string, serviceName string, port int) (*Direct, error) {
+	if serviceName == "" {
+		return nil, fmt.Errorf("serviceName must not be empty")
+	}
+	return newDirect(&Direct{namespace: namespace, serviceName: serviceName, port: port})
+}
+
+// NewDirectToPods returns a Direct dialing port of a ready Pod matching
+// labelSelector, picked again on every Start.
+func NewDirectToPods(podClient v1.PodController, namespace string, labelSelector string, port int) (*Direct, error) {
+	if podClient == nil {
+		return nil, fmt.Errorf("podClient must not be nil")
+	}
+	if labelSelector == "" {
+		return nil, fmt.Errorf("labelSelector must not be empty")
+	}
+	return newDirect(&Direct{podClient: podClient, namespace: namespace, labelSelector: labelSelector, port: port})
+}
+
+func newDirect(d *Direct) (*Direct, error) {
+	if d.namespace == "" {
+		return nil, fmt.Errorf("namespace must not be empty")
+	}
+	if d.port < 1 || d.port > 65535 {
+		return nil, fmt.Errorf("invalid port %d", d.port)
+	}
+	return d, nil
+}
+
+// Start picks the address of the proxy and checks that it accepts
+// connections.
+func (d *Direct) Start() error {
+	host := fmt.Sprintf("%s.%s.svc", d.serviceName, d.namespace)
+	if d.serviceName == "" {
+		var err error
+		host, err = d.podIP()
+		if err != nil {
+			return err
+		}
+	}
+
+	address := net.JoinHostPort(host, strconv.Itoa(d.port))
+	conn, err := net.DialTimeout("tcp", address, directDialTimeout)
+	if err != nil {
+		return fmt.Errorf("proxy unreachable at %s: %w", address, err)
+	}
+	conn.Close()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if host != d.host {
+		logrus.Infof("Dialing proxy directly at %s", address)
+	}
+	d.host = host
+	return nil
+}
+
+// Stop does nothing, as no connection is held between sessions.
+func (d *Direct) Stop() {}
+
+// ServerURL returns the URL of the proxy picked by the last successful Start,
+// or an error if none succeeded yet.
+func (d *Direct) ServerURL() (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.host == "" {
+		return "", fmt.Errorf("proxy address not picked yet, Start must succeed first")
+	}
+	return fmt.Sprintf("wss://%s%s", net.JoinHostPort(d.host, strconv.Itoa(d.port)), directServerPath), nil
+}
+
+func (d *Direct) podIP() (string, error) {
+	pods, err := d.podClient.List(d.namespace, metav1.ListOptions{
+		LabelSelector: d.labelSelector,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var ips []string
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if podReady(pod) && pod.Status.PodIP != "" {
+			ips = append(ips, pod.Status.PodIP)
+		}
+	}
+	if len(ips) == 0 {
+		return "", fmt.Errorf("no ready pod found with label selector %q", d.labelSelector)
+	}
+	return ips[rand.Intn(len(ips))], nil
+}
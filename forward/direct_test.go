@@ -0,0 +1,193 @@
+package forward
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rancher/remotedialer"
+	"github.com/rancher/remotedialer-proxy/proxyclient"
+	v1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakePods lists pods, remembering the label selector it was asked for.
+type fakePods struct {
+	v1.PodController
+	pods     []corev1.Pod
+	err      error
+	selector string
+}
+
+func (f *fakePods) List(_ string, opts metav1.ListOptions) (*corev1.PodList, error) {
+	f.selector = opts.LabelSelector
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &corev1.PodList{Items: f.pods}, nil
+}
+
+func testPod(name, ip string, ready bool) corev1.Pod {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	return corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: name},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			PodIP:      ip,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}},
+		},
+	}
+}
+
+// listen starts a TCP listener accepting and closing connections, standing in
+// for the proxy, and returns its port.
+func listen(t *testing.T) int {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestNewDirect(t *testing.T) {
+	pods := &fakePods{}
+	tests := []struct {
+		name    string
+		new     func() (*Direct, error)
+		wantErr string
+	}{
+		{name: "service", new: func() (*Direct, error) { return NewDirectToService("test-namespace", "proxy", 5555) }},
+		{name: "pods", new: func() (*Direct, error) { return NewDirectToPods(pods, "test-namespace", "app=proxy", 5555) }},
+		{name: "missing service", new: func() (*Direct, error) { return NewDirectToService("test-namespace", "", 5555) }, wantErr: "serviceName must not be empty"},
+		{name: "missing namespace", new: func() (*Direct, error) { return NewDirectToService("", "proxy", 5555) }, wantErr: "namespace must not be empty"},
+		{name: "invalid port", new: func() (*Direct, error) { return NewDirectToService("test-namespace", "proxy", 0) }, wantErr: "invalid port 0"},
+		{name: "missing pod client", new: func() (*Direct, error) { return NewDirectToPods(nil, "test-namespace", "app=proxy", 5555) }, wantErr: "podClient must not be nil"},
+		{name: "missing selector", new: func() (*Direct, error) { return NewDirectToPods(pods, "test-namespace", "", 5555) }, wantErr: "labelSelector must not be empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := tt.new()
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			_, err = d.ServerURL()
+			assert.ErrorContains(t, err, "Start must succeed first")
+		})
+	}
+}
+
+func TestDirectToPods(t *testing.T) {
+	port := listen(t)
+	tests := []struct {
+		name    string
+		pods    []corev1.Pod
+		wantURL string
+		wantErr string
+	}{
+		{
+			name:    "ready pod",
+			pods:    []corev1.Pod{testPod("proxy-0", "10.0.0.1", false), testPod("proxy-1", "127.0.0.1", true)},
+			wantURL: "wss://127.0.0.1:" + strconv.Itoa(port) + "/connect",
+		},
+		{
+			name:    "no ready pod",
+			pods:    []corev1.Pod{testPod("proxy-0", "127.0.0.1", false), testPod("proxy-1", "", true)},
+			wantErr: `no ready pod found with label selector "app=proxy"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pods := &fakePods{pods: tt.pods}
+			d, err := NewDirectToPods(pods, "test-namespace", "app=proxy", port)
+			require.NoError(t, err)
+
+			err = d.Start()
+			assert.Equal(t, "app=proxy", pods.selector)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				_, err = d.ServerURL()
+				assert.Error(t, err, "no URL without a successful Start")
+				return
+			}
+			require.NoError(t, err)
+			url, err := d.ServerURL()
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantURL, url)
+		})
+	}
+}
+
+func TestDirectUnreachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	pods := &fakePods{pods: []corev1.Pod{testPod("proxy-0", "127.0.0.1", true)}}
+	d, err := NewDirectToPods(pods, "test-namespace", "app=proxy", port)
+	require.NoError(t, err)
+	assert.ErrorContains(t, d.Start(), "proxy unreachable at 127.0.0.1:"+strconv.Itoa(port))
+	_, err = d.ServerURL()
+	assert.Error(t, err)
+
+	// The URL follows the pod picked by the last successful Start
+	pods.pods = []corev1.Pod{testPod("proxy-1", "127.0.0.1", true)}
+	d.port = listen(t)
+	require.NoError(t, d.Start())
+	url, err := d.ServerURL()
+	require.NoError(t, err)
+	assert.Equal(t, "wss://127.0.0.1:"+strconv.Itoa(d.port)+"/connect", url)
+}
+
+func TestDirectConnectsProxyClient(t *testing.T) {
+	server := remotedialer.New(func(*http.Request) (string, bool, error) {
+		return "client-id", true, nil
+	}, remotedialer.DefaultErrorWriter)
+	mux := http.NewServeMux()
+	mux.Handle("/connect", server)
+	ts := httptest.NewTLSServer(mux)
+	defer ts.Close()
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(ts.Certificate())
+
+	pods := &fakePods{pods: []corev1.Pod{testPod("proxy-0", "127.0.0.1", true)}}
+	d, err := NewDirectToPods(pods, "test-namespace", "app=proxy", ts.Listener.Addr().(*net.TCPAddr).Port)
+	require.NoError(t, err)
+
+	c, err := proxyclient.New(context.Background(), "secret", "test-namespace", "", "", nil, d,
+		proxyclient.WithoutSecretWatcher(), proxyclient.WithForwardedPorts(6666), proxyclient.WithTLSConfig(&tls.Config{RootCAs: rootCAs}))
+	require.NoError(t, err)
+	require.NoError(t, c.Start(context.Background()))
+	defer func() {
+		c.Stop()
+		assert.NoError(t, c.Wait())
+	}()
+
+	require.Eventually(t, func() bool {
+		return server.HasSession("client-id")
+	}, 5*time.Second, 10*time.Millisecond, "client did not connect to the pod picked by Direct")
+}
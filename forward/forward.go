@@ -28,6 +28,7 @@ type PortForward struct {
 	podClient     v1.PodController
 	namespace     string
 	labelSelector string
+	podName       string // forward to this Pod rather than one matching labelSelector
 	ports         []string
 
 	readyCh  chan struct{}
@@ -118,11 +119,15 @@ func (r *PortForward) Start() error {
 }
 
 func (r *PortForward) runForwarder(ctx context.Context, readyCh chan struct{}, ports []string) error {
-	podName, err := lookForPodName(ctx, r.namespace, r.labelSelector, r.podClient)
-	if err != nil {
-		return err
+	podName := r.podName
+	if podName == "" {
+		var err error
+		podName, err = lookForPodName(ctx, r.namespace, r.labelSelector, r.podClient)
+		if err != nil {
+			return err
+		}
+		logrus.Infof("Selected pod %q for label %q", podName, r.labelSelector)
 	}
-	logrus.Infof("Selected pod %q for label %q", podName, r.labelSelector)
 
 	path := fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/portforward", r.namespace, podName)
 	hostIP := strings.TrimPrefix(r.restConfig.Host, "https://")
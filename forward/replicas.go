@@ -0,0 +1,129 @@
+package forward
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/rancher/remotedialer-proxy/proxyclient"
+	v1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+// Replicas is a proxyclient.ReplicaSource port-forwarding to every ready
+// proxy Pod matching a label selector, each on its own local port.
+type Replicas struct {
+	restConfig    *rest.Config
+	podClient     v1.PodController
+	namespace     string
+	labelSelector string
+	remotePort    int
+
+	mu       sync.Mutex
+	replicas map[string]proxyclient.Replica
+}
+
+func NewReplicas(restConfig *rest.Config, podClient v1.PodController, namespace string, labelSelector string, remotePort int) (*Replicas, error) {
+	if restConfig == nil {
+		return nil, fmt.Errorf("restConfig must not be nil")
+	}
+	if podClient == nil {
+		return nil, fmt.Errorf("podClient must not be nil")
+	}
+	if labelSelector == "" {
+		return nil, fmt.Errorf("labelSelector must not be empty")
+	}
+	if namespace == "" {
+		return nil, fmt.Errorf("namespace must not be empty")
+	}
+	if remotePort < 1 || remotePort > 65535 {
+		return nil, fmt.Errorf("invalid remotePort %d", remotePort)
+	}
+
+	return &Replicas{
+		restConfig:    restConfig,
+		podClient:     podClient,
+		namespace:     namespace,
+		labelSelector: labelSelector,
+		remotePort:    remotePort,
+		replicas:      map[string]proxyclient.Replica{},
+	}, nil
+}
+
+// Replicas returns a replica for every ready Pod, keeping the local port of
+// Pods it already returned.
+func (r *Replicas) Replicas(context.Context) ([]proxyclient.Replica, error) {
+	pods, err := r.podClient.List(r.namespace, metav1.ListOptions{
+		LabelSelector: r.labelSelector,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	replicas := make(map[string]proxyclient.Replica, len(pods.Items))
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if !podReady(pod) {
+			continue
+		}
+		if replica, ok := r.replicas[pod.Name]; ok {
+			replicas[pod.Name] = replica
+			continue
+		}
+
+		localPort, err := freeLocalPort()
+		if err != nil {
+			return nil, err
+		}
+		replicas[pod.Name] = proxyclient.Replica{
+			Name: pod.Name,
+			Endpoint: proxyclient.ServerEndpoint{
+				URL: fmt.Sprintf("wss://127.0.0.1:%d/connect", localPort),
+			},
+			Forwarder: &PortForward{
+				restConfig:    r.restConfig,
+				podClient:     r.podClient,
+				namespace:     r.namespace,
+				labelSelector: r.labelSelector,
+				podName:       pod.Name,
+				ports:         []string{fmt.Sprintf("%d:%d", localPort, r.remotePort)},
+				readyCh:       make(chan struct{}, 1),
+			},
+		}
+	}
+	r.replicas = replicas
+
+	result := make([]proxyclient.Replica, 0, len(replicas))
+	for _, replica := range replicas {
+		result = append(result, replica)
+	}
+	return result, nil
+}
+
+func podReady(pod *corev1.Pod) bool {
+	if pod.DeletionTimestamp != nil || pod.Status.Phase != corev1.PodRunning {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// freeLocalPort returns a loopback port that is free at the time of the call.
+func freeLocalPort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,173 @@
+package forward
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/rancher/remotedialer-proxy/proxyclient"
+	v1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+func TestNewReplicas(t *testing.T) {
+	pods := &fakePods{}
+	tests := []struct {
+		name          string
+		restConfig    *rest.Config
+		podClient     v1.PodController
+		namespace     string
+		labelSelector string
+		remotePort    int
+		wantErr       string
+	}{
+		{name: "valid", restConfig: &rest.Config{}, podClient: pods, namespace: "test-namespace", labelSelector: "app=proxy", remotePort: 5555},
+		{name: "missing rest config", podClient: pods, namespace: "test-namespace", labelSelector: "app=proxy", remotePort: 5555, wantErr: "restConfig must not be nil"},
+		{name: "missing pod client", restConfig: &rest.Config{}, namespace: "test-namespace", labelSelector: "app=proxy", remotePort: 5555, wantErr: "podClient must not be nil"},
+		{name: "missing selector", restConfig: &rest.Config{}, podClient: pods, namespace: "test-namespace", remotePort: 5555, wantErr: "labelSelector must not be empty"},
+		{name: "missing namespace", restConfig: &rest.Config{}, podClient: pods, labelSelector: "app=proxy", remotePort: 5555, wantErr: "namespace must not be empty"},
+		{name: "invalid port", restConfig: &rest.Config{}, podClient: pods, namespace: "test-namespace", labelSelector: "app=proxy", remotePort: 65536, wantErr: "invalid remotePort 65536"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewReplicas(tt.restConfig, tt.podClient, tt.namespace, tt.labelSelector, tt.remotePort)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestPodReady(t *testing.T) {
+	terminating := testPod("proxy-0", "10.0.0.1", true)
+	terminating.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	pending := testPod("proxy-0", "10.0.0.1", true)
+	pending.Status.Phase = corev1.PodPending
+	noCondition := testPod("proxy-0", "10.0.0.1", true)
+	noCondition.Status.Conditions = nil
+
+	tests := []struct {
+		name string
+		pod  corev1.Pod
+		want bool
+	}{
+		{name: "ready", pod: testPod("proxy-0", "10.0.0.1", true), want: true},
+		{name: "not ready", pod: testPod("proxy-0", "10.0.0.1", false)},
+		{name: "terminating", pod: terminating},
+		{name: "pending", pod: pending},
+		{name: "no ready condition", pod: noCondition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, podReady(&tt.pod))
+		})
+	}
+}
+
+func TestReplicas(t *testing.T) {
+	pods := &fakePods{}
+	r, err := NewReplicas(&rest.Config{}, pods, "test-namespace", "app=proxy", 5555)
+	require.NoError(t, err)
+
+	byName := func(replicas []proxyclient.Replica) map[string]proxyclient.Replica {
+		result := map[string]proxyclient.Replica{}
+		for _, replica := range replicas {
+			result[replica.Name] = replica
+		}
+		return result
+	}
+
+	// Every step lists the Pods returned by the API and the replicas expected
+	// from them, in order, carried over between steps like endpoint updates.
+	steps := []struct {
+		name string
+		pods []corev1.Pod
+		want []string
+	}{
+		{
+			name: "only ready pods",
+			pods: []corev1.Pod{testPod("proxy-0", "10.0.0.1", true), testPod("proxy-1", "10.0.0.2", false)},
+			want: []string{"proxy-0"},
+		},
+		{
+			name: "pod becomes ready",
+			pods: []corev1.Pod{testPod("proxy-0", "10.0.0.1", true), testPod("proxy-1", "10.0.0.2", true)},
+			want: []string{"proxy-0", "proxy-1"},
+		},
+		{
+			name: "pod goes away",
+			pods: []corev1.Pod{testPod("proxy-1", "10.0.0.2", true), testPod("proxy-2", "10.0.0.3", true)},
+			want: []string{"proxy-1", "proxy-2"},
+		},
+		{
+			name: "no pods",
+			want: []string{},
+		},
+	}
+
+	previous := map[string]proxyclient.Replica{}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			pods.pods = step.pods
+			replicas, err := r.Replicas(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, "app=proxy", pods.selector)
+
+			names := make([]string, 0, len(replicas))
+			for _, replica := range replicas {
+				names = append(names, replica.Name)
+			}
+			sort.Strings(names)
+			assert.Equal(t, step.want, names)
+
+			current := byName(replicas)
+			for name, replica := range current {
+				forwarder, ok := replica.Forwarder.(*PortForward)
+				require.True(t, ok, "replica %s should port-forward", name)
+				assert.Equal(t, name, forwarder.podName)
+				require.Len(t, forwarder.ports, 1)
+				var localPort, remotePort int
+				_, err := fmt.Sscanf(forwarder.ports[0], "%d:%d", &localPort, &remotePort)
+				require.NoError(t, err)
+				assert.Equal(t, 5555, remotePort)
+				assert.Equal(t, fmt.Sprintf("wss://127.0.0.1:%d/connect", localPort), replica.Endpoint.URL)
+
+				// Pods already returned keep their local port and forwarder
+				if old, ok := previous[name]; ok {
+					assert.Equal(t, old.Endpoint, replica.Endpoint)
+					assert.Same(t, old.Forwarder, replica.Forwarder)
+				}
+			}
+			previous = current
+		})
+	}
+}
+
+func TestReplicasListError(t *testing.T) {
+	pods := &fakePods{pods: []corev1.Pod{testPod("proxy-0", "10.0.0.1", true)}}
+	r, err := NewReplicas(&rest.Config{}, pods, "test-namespace", "app=proxy", 5555)
+	require.NoError(t, err)
+	replicas, err := r.Replicas(context.Background())
+	require.NoError(t, err)
+	require.Len(t, replicas, 1)
+
+	// A failed list keeps the known replicas for the next update
+	pods.err = errors.New("connection refused")
+	_, err = r.Replicas(context.Background())
+	assert.ErrorContains(t, err, "connection refused")
+
+	pods.err = nil
+	again, err := r.Replicas(context.Background())
+	require.NoError(t, err)
+	require.Len(t, again, 1)
+	assert.Equal(t, replicas[0].Endpoint, again[0].Endpoint)
+}
@@ -0,0 +1,58 @@
+package v1
+
+import (
+	"github.com/rancher/wrangler/v3/pkg/genericcondition"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// RouteReady is true once the route listener is bound and accepting
+	// connections.
+	RouteReady = "Ready"
+	// RouteClientAvailable is true while a tunnel client matching the route's
+	// selector is connected.
+	RouteClientAvailable = "ClientAvailable"
+)
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// TunnelRoute makes the proxy listen on a port and relay every connection to a
+// peer address dialed through a remotedialer tunnel client.
+type TunnelRoute struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TunnelRouteSpec   `json:"spec"`
+	Status TunnelRouteStatus `json:"status,omitempty"`
+}
+
+type TunnelRouteSpec struct {
+	// ListenPort is the TCP port the proxy listens on for this route.
+	ListenPort int32 `json:"listenPort"`
+	// Peer is the host:port dialed on the tunnel client side. An empty host
+	// dials the client itself, e.g. ":6666".
+	Peer string `json:"peer"`
+	// ClientSelector picks the tunnel clients connections are dialed through.
+	ClientSelector ClientSelector `json:"clientSelector,omitempty"`
+	// ClientWaitTimeout is how long an accepted connection waits for a
+	// matching client to connect. Defaults to 10s.
+	ClientWaitTimeout *metav1.Duration `json:"clientWaitTimeout,omitempty"`
+	// DialTimeout bounds dialing the peer through the tunnel. No timeout when
+	// unset.
+	DialTimeout *metav1.Duration `json:"dialTimeout,omitempty"`
+}
+
+type ClientSelector struct {
+	// ClientIDs restricts the route to these tunnel client IDs. All connected
+	// clients are used when empty.
+	ClientIDs []string `json:"clientIDs,omitempty"`
+	// Policy is how a client is picked among the matching ones: random or
+	// round-robin. Defaults to random.
+	Policy string `json:"policy,omitempty"`
+}
+
+type TunnelRouteStatus struct {
+	ObservedGeneration int64                               `json:"observedGeneration,omitempty"`
+	Conditions         []genericcondition.GenericCondition `json:"conditions,omitempty"`
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+
+	controllergen "github.com/rancher/wrangler/v3/pkg/controller-gen"
+	"github.com/rancher/wrangler/v3/pkg/controller-gen/args"
+	"github.com/sirupsen/logrus"
+
+	remotedialerv1 "github.com/rancher/remotedialer-proxy/pkg/apis/remotedialer.cattle.io/v1"
+	"github.com/rancher/remotedialer-proxy/pkg/crds"
+)
+
+func main() {
+	os.Unsetenv("GOPATH")
+	controllergen.Run(args.Options{
+		OutputPackage: "github.com/rancher/remotedialer-proxy/pkg/generated",
+		Boilerplate:   "scripts/boilerplate.go.txt",
+		Groups: map[string]args.Group{
+			"remotedialer.cattle.io": {
+				Types: []interface{}{
+					remotedialerv1.TunnelRoute{},
+				},
+				GenerateTypes: true,
+			},
+		},
+	})
+
+	if err := crds.WriteFile("charts/remotedialer-proxy/crds/tunnelroutes.yaml"); err != nil {
+		logrus.Fatalf("writing CRDs: %v", err)
+	}
+}
@@ -0,0 +1,30 @@
+// Package crds defines the CustomResourceDefinitions served by the proxy.
+package crds
+
+import (
+	"github.com/rancher/wrangler/v3/pkg/crd"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	remotedialerv1 "github.com/rancher/remotedialer-proxy/pkg/apis/remotedialer.cattle.io/v1"
+)
+
+// List returns the CRDs of the remotedialer.cattle.io group.
+func List() []crd.CRD {
+	tunnelRoute := crd.CRD{
+		GVK:          schema.GroupVersionKind{Group: "remotedialer.cattle.io", Version: "v1", Kind: "TunnelRoute"},
+		PluralName:   "tunnelroutes",
+		SingularName: "tunnelroute",
+		Status:       true,
+	}.
+		WithSchemaFromStruct(remotedialerv1.TunnelRoute{}).
+		WithColumn("Listen Port", ".spec.listenPort").
+		WithColumn("Peer", ".spec.peer").
+		WithColumn("Ready", `.status.conditions[?(@.type=="Ready")].status`)
+
+	return []crd.CRD{tunnelRoute}
+}
+
+// WriteFile writes the CRDs as YAML to filename.
+func WriteFile(filename string) error {
+	return crd.WriteFile(filename, List())
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+// runAdminServer serves operational endpoints such as metrics on a plain HTTP
+// port, separate from the tunnel and proxy listeners. Reads are open, changes
+// require the bearer token returned by token.
+func runAdminServer(ctx context.Context, port int, token func() string) error {
+	srv := &http.Server{
+		Addr:    fmt.Sprintf("0.0.0.0:%d", port),
+		Handler: newAdminRouter(token),
+	}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Shutdown(context.Background())
+	}()
+
+	logrus.Infof("admin server listening on %s", srv.Addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func newAdminRouter(token func() string) *mux.Router {
+	router := mux.NewRouter()
+	router.Handle("/metrics", promhttp.Handler()).Methods(http.MethodGet)
+	router.HandleFunc("/loglevel", getLogLevel).Methods(http.MethodGet)
+	router.HandleFunc("/loglevel", requireAdminToken(token, setLogLevel)).Methods(http.MethodPut, http.MethodPost)
+	return router
+}
+
+// requireAdminToken only passes requests carrying the current ADMIN_TOKEN as
+// a bearer token on to next. Without ADMIN_TOKEN every request is refused.
+func requireAdminToken(token func() string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		expected := token()
+		if expected == "" {
+			http.Error(w, "changes through the admin server are disabled, set ADMIN_TOKEN to enable them", http.StatusForbidden)
+			return
+		}
+		got, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(expected)) != 1 {
+			logrus.Warnf("admin request %s %s from %s refused: invalid token", req.Method, req.URL.Path, req.RemoteAddr)
+			http.Error(w, "invalid admin token", http.StatusUnauthorized)
+			return
+		}
+		next(w, req)
+	}
+}
+
+func getLogLevel(w http.ResponseWriter, _ *http.Request) {
+	fmt.Fprintln(w, logrus.GetLevel().String())
+}
+
+// setLogLevel changes the log level until the next restart or configuration
+// reload. The level is read from the request body, e.g. "debug".
+func setLogLevel(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(req.Body, 64))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	level, err := logrus.ParseLevel(strings.TrimSpace(string(body)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	logrus.Infof("log level changed from %s to %s through the admin endpoint", logrus.GetLevel(), level)
+	logrus.SetLevel(level)
+	fmt.Fprintln(w, level.String())
+}
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminLogLevel(t *testing.T) {
+	defer logrus.SetLevel(logrus.GetLevel())
+	logrus.SetLevel(logrus.InfoLevel)
+
+	router := newAdminRouter(func() string { return "admin-token" })
+	request := func(method, body string) *http.Request {
+		req := httptest.NewRequest(method, "/loglevel", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer admin-token")
+		return req
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/loglevel", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "info\n", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, request(http.MethodPut, "debug\n"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, logrus.DebugLevel, logrus.GetLevel())
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, request(http.MethodPut, "loud"))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, logrus.DebugLevel, logrus.GetLevel())
+}
+
+func TestAdminChangesRequireToken(t *testing.T) {
+	defer logrus.SetLevel(logrus.GetLevel())
+	logrus.SetLevel(logrus.InfoLevel)
+
+	for _, tt := range []struct {
+		name, token, authorization string
+		want                       int
+	}{
+		{name: "no token configured", authorization: "Bearer ", want: http.StatusForbidden},
+		{name: "missing", token: "admin-token", want: http.StatusUnauthorized},
+		{name: "wrong", token: "admin-token", authorization: "Bearer other", want: http.StatusUnauthorized},
+		{name: "not a bearer token", token: "admin-token", authorization: "admin-token", want: http.StatusUnauthorized},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newAdminRouter(func() string { return tt.token })
+			for _, method := range []string{http.MethodPut, http.MethodPost} {
+				req := httptest.NewRequest(method, "/loglevel", strings.NewReader("debug"))
+				if tt.authorization != "" {
+					req.Header.Set("Authorization", tt.authorization)
+				}
+				rec := httptest.NewRecorder()
+				router.ServeHTTP(rec, req)
+				assert.Equal(t, tt.want, rec.Code, method)
+			}
+			assert.Equal(t, logrus.InfoLevel, logrus.GetLevel())
+
+			// Reads stay open
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/loglevel", nil))
+			assert.Equal(t, http.StatusOK, rec.Code)
+			rec = httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			assert.Equal(t, http.StatusOK, rec.Code)
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	apiServiceAvailabilityInterval = 10 * time.Second
+
+	apiServiceUnavailableReason = "NoTunnelClient"
+	apiServiceAvailableType     = "Available"
+)
+
+var apiServiceResource = schema.GroupVersionResource{
+	Group:    "apiregistration.k8s.io",
+	Version:  "v1",
+	Resource: "apiservices",
+}
+
+// apiServiceController keeps the caBundle of the configured APIService
+// objects in sync with the CA of the serving certificate, so aggregation keeps
+// working when the CA is regenerated or the certificate reissued. It can also
+// mark the APIServices unavailable while no tunnel client is connected.
+type apiServiceController struct {
+	apiServices dynamic.ResourceInterface
+	names       []string
+	caNamespace string
+	caName      string
+	caKey       string
+}
+
+// newAPIServiceController reads the CA from the dynamiclistener CA secret, or
+// from the ca.crt key of TLSSecret when the proxy serves that certificate.
+func newAPIServiceController(client dynamic.Interface, cfg *Config) *apiServiceController {
+	caName, caKey := caSecret(cfg)
+	return &apiServiceController{
+		apiServices: client.Resource(apiServiceResource),
+		names:       cfg.APIServices,
+		caNamespace: cfg.CertCANamespace,
+		caName:      caName,
+		caKey:       caKey,
+	}
+}
+
+// OnCAChange is a secret OnChange handler patching the caBundle of every
+// APIService when the CA secret changes. Errors are returned so the secret is
+// requeued and the patch retried.
+func (c *apiServiceController) OnCAChange(_ string, secret *corev1.Secret) (*corev1.Secret, error) {
+	if secret == nil || secret.Namespace != c.caNamespace || secret.Name != c.caName {
+		return secret, nil
+	}
+
+	caBundle := secret.Data[c.caKey]
+	if len(caBundle) == 0 {
+		return secret, fmt.Errorf("CA secret %s/%s missing %s field", c.caNamespace, c.caName, c.caKey)
+	}
+
+	var errs []error
+	for _, name := range c.names {
+		if err := c.syncCABundle(context.TODO(), name, caBundle); err != nil {
+			errs = append(errs, fmt.Errorf("apiservice %s: %w", name, err))
+		}
+	}
+	return secret, errors.Join(errs...)
+}
+
+func (c *apiServiceController) syncCABundle(ctx context.Context, name string, caBundle []byte) error {
+	apiService, err := c.apiServices.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	current, _, err := unstructured.NestedString(apiService.Object, "spec", "caBundle")
+	if err != nil {
+		return err
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(current); err == nil && bytes.Equal(decoded, caBundle) {
+		return nil
+	}
+
+	patch := fmt.Sprintf(`{"spec":{"caBundle":%q}}`, base64.StdEncoding.EncodeToString(caBundle))
+	if _, err := c.apiServices.Patch(ctx, name, types.MergePatchType, []byte(patch), metav1.PatchOptions{}); err != nil {
+		return err
+	}
+	logrus.Infof("updated caBundle of apiservice %s from secret %s/%s", name, c.caNamespace, c.caName)
+	return nil
+}
+
+// markUnavailable sets the Available condition of every APIService to False.
+// kube-aggregator recomputes that condition on its own, so this is repeated
+// for as long as no tunnel client is connected.
+func (c *apiServiceController) markUnavailable(ctx context.Context, idleSince time.Time) {
+	for _, name := range c.names {
+		if err := c.setUnavailable(ctx, name, idleSince); err != nil {
+			logrus.Errorf("marking apiservice %s unavailable failed: %v", name, err)
+		}
+	}
+}
+
+func (c *apiServiceController) setUnavailable(ctx context.Context, name string, idleSince time.Time) error {
+	apiService, err := c.apiServices.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	conditions, _, err := unstructured.NestedSlice(apiService.Object, "status", "conditions")
+	if err != nil {
+		return err
+	}
+
+	unavailable := map[string]interface{}{
+		"type":               apiServiceAvailableType,
+		"status":             string(metav1.ConditionFalse),
+		"reason":             apiServiceUnavailableReason,
+		"message":            fmt.Sprintf("no remotedialer-proxy tunnel client connected since %s", idleSince.UTC().Format(time.RFC3339)),
+		"lastTransitionTime": idleSince.UTC().Format(time.RFC3339),
+	}
+
+	found := false
+	for i, condition := range conditions {
+		condition, ok := condition.(map[string]interface{})
+		if !ok || condition["type"] != apiServiceAvailableType {
+			continue
+		}
+		found = true
+		if condition["status"] == unavailable["status"] && condition["reason"] == unavailable["reason"] {
+			return nil
+		}
+		conditions[i] = unavailable
+	}
+	if !found {
+		conditions = append(conditions, unavailable)
+	}
+
+	if err := unstructured.SetNestedSlice(apiService.Object, conditions, "status", "conditions"); err != nil {
+		return err
+	}
+	if _, err := c.apiServices.UpdateStatus(ctx, apiService, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	logrus.Warnf("marked apiservice %s unavailable: no tunnel client connected", name)
+	return nil
+}
+
+func (c *apiServiceController) watchAvailability(ctx context.Context, clients *clientWatcher, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			idleSince := clients.IdleSince()
+			if idleSince.IsZero() {
+				continue
+			}
+			c.markUnavailable(ctx, idleSince)
+		}
+	}
+}
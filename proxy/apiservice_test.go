@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func newTestAPIService(name string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apiregistration.k8s.io/v1",
+		"kind":       "APIService",
+		"metadata":   map[string]interface{}{"name": name},
+		"spec":       map[string]interface{}{"group": "ext.cattle.io", "version": "v1"},
+		"status": map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Available", "status": "True", "reason": "Passed"},
+			},
+		},
+	}}
+}
+
+func TestAPIServiceController(t *testing.T) {
+	ctx := context.Background()
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{apiServiceResource: "APIServiceList"},
+		newTestAPIService("v1.ext.cattle.io"))
+
+	controller := newAPIServiceController(client, &Config{
+		CertCANamespace: "cattle-system",
+		CAName:          "test-ca",
+		APIServices:     []string{"v1.ext.cattle.io"},
+	})
+
+	getAPIService := func() *unstructured.Unstructured {
+		apiService, err := client.Resource(apiServiceResource).Get(ctx, "v1.ext.cattle.io", metav1.GetOptions{})
+		require.NoError(t, err)
+		return apiService
+	}
+
+	// Secrets other than the CA are ignored
+	_, err := controller.OnCAChange("", &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "cattle-system", Name: "other"},
+		Data:       map[string][]byte{corev1.TLSCertKey: []byte("other")},
+	})
+	require.NoError(t, err)
+	_, found, _ := unstructured.NestedString(getAPIService().Object, "spec", "caBundle")
+	assert.False(t, found)
+
+	// The CA is copied into the caBundle
+	_, err = controller.OnCAChange("", &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "cattle-system", Name: "test-ca"},
+		Data:       map[string][]byte{corev1.TLSCertKey: []byte("ca-data")},
+	})
+	require.NoError(t, err)
+	caBundle, _, _ := unstructured.NestedString(getAPIService().Object, "spec", "caBundle")
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("ca-data")), caBundle)
+
+	// Missing APIServices are reported so the secret is requeued
+	controller.names = append(controller.names, "v2.ext.cattle.io")
+	_, err = controller.OnCAChange("", &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "cattle-system", Name: "test-ca"},
+		Data:       map[string][]byte{corev1.TLSCertKey: []byte("ca-data")},
+	})
+	assert.ErrorContains(t, err, "v2.ext.cattle.io")
+	controller.names = controller.names[:1]
+
+	// Marking unavailable replaces the Available condition
+	controller.markUnavailable(ctx, time.Now())
+	conditions, _, _ := unstructured.NestedSlice(getAPIService().Object, "status", "conditions")
+	require.Len(t, conditions, 1)
+	condition := conditions[0].(map[string]interface{})
+	assert.Equal(t, "False", condition["status"])
+	assert.Equal(t, apiServiceUnavailableReason, condition["reason"])
+}
+
+func TestAPIServiceControllerWithTLSSecret(t *testing.T) {
+	ctx := context.Background()
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{apiServiceResource: "APIServiceList"},
+		newTestAPIService("v1.ext.cattle.io"))
+
+	controller := newAPIServiceController(client, &Config{
+		CertCANamespace: "cattle-system",
+		CAName:          "test-ca",
+		TLSSecret:       "serving-cert",
+		APIServices:     []string{"v1.ext.cattle.io"},
+	})
+
+	// The dynamiclistener CA is not used with a TLS secret
+	_, err := controller.OnCAChange("", &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "cattle-system", Name: "test-ca"},
+		Data:       map[string][]byte{corev1.TLSCertKey: []byte("stale-ca")},
+	})
+	require.NoError(t, err)
+
+	_, err = controller.OnCAChange("", &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "cattle-system", Name: "serving-cert"},
+		Data:       map[string][]byte{corev1.TLSCertKey: []byte("leaf"), "ca.crt": []byte("issuer")},
+	})
+	require.NoError(t, err)
+	apiService, err := client.Resource(apiServiceResource).Get(ctx, "v1.ext.cattle.io", metav1.GetOptions{})
+	require.NoError(t, err)
+	caBundle, _, _ := unstructured.NestedString(apiService.Object, "spec", "caBundle")
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("issuer")), caBundle)
+
+	_, err = controller.OnCAChange("", &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "cattle-system", Name: "serving-cert"},
+		Data:       map[string][]byte{corev1.TLSCertKey: []byte("leaf")},
+	})
+	assert.ErrorContains(t, err, "CA secret cattle-system/serving-cert missing ca.crt field")
+}
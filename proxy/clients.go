@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rancher/remotedialer"
+)
+
+const clientWatchInterval = 1 * time.Second
+
+type clientEventType int
+
+const (
+	clientConnected clientEventType = iota
+	clientDisconnected
+)
+
+// clientEvent reports a remotedialer client connecting or disconnecting.
+// Peer events are about clients held by a peer replica rather than this one.
+type clientEvent struct {
+	Type     clientEventType
+	ClientID string
+	Time     time.Time
+	Peer     bool
+}
+
+// clientWatcher polls the remotedialer server for connected clients, since the
+// server offers no connect or disconnect hooks, and tells its handlers about
+// every change. With peering, clients only held by peer replicas are tracked
+// too, so that every replica agrees on whether a client is available.
+type clientWatcher struct {
+	server *remotedialer.Server
+	peers  func() []string // clients reachable through peer replicas, nil without peering
+
+	mu          sync.Mutex
+	handlers    []func(clientEvent)
+	clients     map[string]time.Time
+	peerClients map[string]time.Time
+	idle        time.Time
+}
+
+func newClientWatcher(server *remotedialer.Server) *clientWatcher {
+	return &clientWatcher{
+		server:      server,
+		clients:     map[string]time.Time{},
+		peerClients: map[string]time.Time{},
+		idle:        time.Now(),
+	}
+}
+
+// OnChange registers a handler called for every client event. Handlers run on
+// the watcher goroutine and should not block.
+func (w *clientWatcher) OnChange(handler func(clientEvent)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.handlers = append(w.handlers, handler)
+}
+
+// Clients returns the connected client IDs and when they connected.
+func (w *clientWatcher) Clients() map[string]time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	clients := make(map[string]time.Time, len(w.clients))
+	for id, connected := range w.clients {
+		clients[id] = connected
+	}
+	return clients
+}
+
+// AllClients returns Clients together with the clients only held by peers,
+// which are dated from when this replica first saw them.
+func (w *clientWatcher) AllClients() map[string]time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	clients := make(map[string]time.Time, len(w.clients)+len(w.peerClients))
+	for id, connected := range w.peerClients {
+		clients[id] = connected
+	}
+	for id, connected := range w.clients {
+		clients[id] = connected
+	}
+	return clients
+}
+
+// IdleSince returns when the last client disconnected, or the zero time while
+// a client is connected to this replica or a peer.
+func (w *clientWatcher) IdleSince() time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.idle
+}
+
+func (w *clientWatcher) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		w.sync(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *clientWatcher) sync(now time.Time) {
+	current := map[string]bool{}
+	for _, id := range w.server.ListClients() {
+		current[id] = true
+	}
+	peers := map[string]bool{}
+	if w.peers != nil {
+		for _, id := range w.peers() {
+			if !current[id] {
+				peers[id] = true
+			}
+		}
+	}
+
+	w.mu.Lock()
+	events := diffClients(w.clients, current, now, false)
+	events = append(events, diffClients(w.peerClients, peers, now, true)...)
+	switch {
+	case len(w.clients) > 0 || len(w.peerClients) > 0:
+		w.idle = time.Time{}
+	case w.idle.IsZero():
+		w.idle = now
+	}
+	handlers := w.handlers
+	w.mu.Unlock()
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].ClientID < events[j].ClientID
+	})
+	for _, event := range events {
+		for _, handler := range handlers {
+			handler(event)
+		}
+	}
+}
+
+// diffClients updates known to the current clients and returns the events
+// telling about the difference.
+func diffClients(known map[string]time.Time, current map[string]bool, now time.Time, peer bool) []clientEvent {
+	var events []clientEvent
+	for id := range current {
+		if _, ok := known[id]; !ok {
+			known[id] = now
+			events = append(events, clientEvent{Type: clientConnected, ClientID: id, Time: now, Peer: peer})
+		}
+	}
+	for id := range known {
+		if !current[id] {
+			delete(known, id)
+			events = append(events, clientEvent{Type: clientDisconnected, ClientID: id, Time: now, Peer: peer})
+		}
+	}
+	return events
+}
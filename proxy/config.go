@@ -1,9 +1,27 @@
 package proxy
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"os"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+const maxPort = 65535
+
+const (
+	ClientSelectionRandom     = "random"      // pick a random connected client for every connection
+	ClientSelectionRoundRobin = "round-robin" // rotate through connected clients
+
+	LogFormatText = "text"
+	LogFormatJSON = "json"
 )
 
 type Config struct {
@@ -12,22 +30,79 @@ type Config struct {
 	CertCANamespace string // certificate secret namespace
 	CertCAName      string // certificate secret name
 	Secret          string // remotedialer secret
+	SecretFile      string // file the remotedialer secret is read from, reloaded on change
 	ProxyPort       int    // tcp remotedialer-proxy port
 	PeerPort        int    // cluster-external service port
 	HTTPSPort       int    // https remotedialer-proxy port
+	AdminPort       int    // optional http port serving metrics, 0 disables it
+	AdminToken      string // bearer token required to change settings through the admin port, changes are disabled without it
+	AdminTokenFile  string // file the admin token is read from, reloaded on change
+	ClientSelection string // policy used to pick a remotedialer client
+	LogLevel        string // logrus level, overrides Debug when set
+	LogFormat       string // log output format, text or json
+	ConfigFile      string // optional file overriding the environment, watched for changes
+	TracingExporter string // OpenTelemetry span exporter: none, otlp or stdout
+	PodName         string // name of the proxy pod, events are recorded on it
+	PodNamespace    string // namespace of the proxy pod, defaults to CertCANamespace
+	EventObject     string // Kind/name in CertCANamespace to record events on instead of the pod
+	StatusConfigMap string // optional ConfigMap in CertCANamespace the tunnel status is written to
+	TLSMinVersion   string // minimum TLS version of the https listener, 1.2 or 1.3
+	TLSSecret       string // externally managed kubernetes.io/tls secret in CertCANamespace, replaces generated certificates
+	CertExpiryDays  int    // regenerate the certificate this many days before it expires, 0 for the default
+	PeerService     string // Service in the Pod namespace whose endpoints are the proxy replicas to peer with
 	Debug           bool
+
+	NoClientEventThreshold time.Duration // how long no client may be connected before a warning event
+	APIServices            []string      // APIService objects whose caBundle is kept in sync with the CA
+	APIServiceAvailability bool          // mark APIServices unavailable while no client is connected
+	TunnelRoutes           bool          // reconcile TunnelRoute resources in CertCANamespace into listeners
+	TLSSANs                []string      // DNS SANs added to the certificate next to TLSName
+	TLSIPSANs              []string      // IP SANs added to the certificate
+	TLSCipherSuites        []string      // TLS 1.2 cipher suites, Go defaults when empty
+	CertRegenerate         bool          // regenerate the certificate when it is about to expire
 }
 
-func requiredString(key string) (string, error) {
-	value := os.Getenv(key)
+// getenvFunc looks up a configuration key, returning "" when it is unset.
+type getenvFunc func(key string) string
+
+func requiredString(getenv getenvFunc, key string) (string, error) {
+	value := getenv(key)
 	if value == "" {
 		return "", fmt.Errorf("%s cannot be empty", key)
 	}
 	return value, nil
 }
 
-func requiredPort(key string) (int, error) {
-	valueStr := os.Getenv(key)
+// requiredSecret reads a secret either directly from key or from the file named
+// by key_FILE. Setting both is an error. The returned path is empty when the
+// value was not read from a file.
+func requiredSecret(getenv getenvFunc, key string) (string, string, error) {
+	value, path, err := optionalSecret(getenv, key)
+	if err == nil && value == "" {
+		return "", "", fmt.Errorf("%s or %s_FILE must be set", key, key)
+	}
+	return value, path, err
+}
+
+// optionalSecret is requiredSecret for secrets that may be left unset.
+func optionalSecret(getenv getenvFunc, key string) (string, string, error) {
+	value := getenv(key)
+	path := getenv(key + "_FILE")
+	switch {
+	case value != "" && path != "":
+		return "", "", fmt.Errorf("only one of %s and %s_FILE can be set", key, key)
+	case path != "":
+		value, err := readSecretFile(path)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read %s_FILE: %w", key, err)
+		}
+		return value, path, nil
+	}
+	return value, "", nil
+}
+
+func requiredPort(getenv getenvFunc, key string) (int, error) {
+	valueStr := getenv(key)
 	port, err := strconv.Atoi(valueStr)
 	if err != nil {
 		return 0, fmt.Errorf("failed to read %s: %w", key, err)
@@ -38,35 +113,369 @@ func requiredPort(key string) (int, error) {
 	return port, nil
 }
 
+func optionalPort(getenv getenvFunc, key string) (int, error) {
+	if getenv(key) == "" {
+		return 0, nil
+	}
+	return requiredPort(getenv, key)
+}
+
+func optionalList(getenv getenvFunc, key string) []string {
+	var values []string
+	for _, value := range strings.Split(getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func optionalInt(getenv getenvFunc, key string, defaultValue int) (int, error) {
+	valueStr := getenv(key)
+	if valueStr == "" {
+		return defaultValue, nil
+	}
+	value, err := strconv.Atoi(valueStr)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", key, err)
+	}
+	return value, nil
+}
+
+func optionalBool(getenv getenvFunc, key string, defaultValue bool) (bool, error) {
+	valueStr := getenv(key)
+	if valueStr == "" {
+		return defaultValue, nil
+	}
+	value, err := strconv.ParseBool(valueStr)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", key, err)
+	}
+	return value, nil
+}
+
+func optionalDuration(getenv getenvFunc, key string, defaultValue time.Duration) (time.Duration, error) {
+	valueStr := getenv(key)
+	if valueStr == "" {
+		return defaultValue, nil
+	}
+	value, err := time.ParseDuration(valueStr)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", key, err)
+	}
+	if value <= 0 {
+		return 0, fmt.Errorf("%s should be greater than 0", key)
+	}
+	return value, nil
+}
+
 func ConfigFromEnvironment() (*Config, error) {
+	return LoadConfig(os.Getenv("CONFIG_FILE"))
+}
+
+// LoadConfig builds the configuration from the environment. When path is set,
+// keys found in that YAML file take precedence over environment variables.
+// The file uses the same keys as the environment, e.g. "PEER_PORT: 6666", so
+// it can be a mounted ConfigMap.
+func LoadConfig(path string) (*Config, error) {
+	getenv := os.Getenv
+	if path != "" {
+		values, err := readConfigFile(path)
+		if err != nil {
+			return nil, err
+		}
+		getenv = func(key string) string {
+			if value, ok := values[key]; ok {
+				return value
+			}
+			return os.Getenv(key)
+		}
+	}
+
+	config, err := configFromLookup(getenv)
+	if err != nil {
+		return nil, err
+	}
+	config.ConfigFile = path
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+	raw := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	values := make(map[string]string, len(raw))
+	for key, value := range raw {
+		if value == nil {
+			continue
+		}
+		str, err := configFileValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in config file %s: %w", key, path, err)
+		}
+		values[key] = str
+	}
+	return values, nil
+}
+
+// configFileValue converts a config file value to the string its environment
+// variable would hold. Lists are joined with commas, as in TLS_SANS.
+func configFileValue(value interface{}) (string, error) {
+	list, ok := value.([]interface{})
+	if !ok {
+		return configFileScalar(value)
+	}
+	items := make([]string, 0, len(list))
+	for _, item := range list {
+		str, err := configFileScalar(item)
+		if err != nil {
+			return "", err
+		}
+		items = append(items, str)
+	}
+	return strings.Join(items, ","), nil
+}
+
+func configFileScalar(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("expected a string, number, boolean or a list of them, got %v", value)
+}
+
+func configFromLookup(getenv getenvFunc) (*Config, error) {
 	var err error
 	var config Config
 
-	if config.TLSName, err = requiredString("TLS_NAME"); err != nil {
+	if config.TLSName, err = requiredString(getenv, "TLS_NAME"); err != nil {
+		return nil, err
+	}
+	if config.CAName, err = requiredString(getenv, "CA_NAME"); err != nil {
+		return nil, err
+	}
+	if config.CertCANamespace, err = requiredString(getenv, "CERT_CA_NAMESPACE"); err != nil {
+		return nil, err
+	}
+	if config.CertCAName, err = requiredString(getenv, "CERT_CA_NAME"); err != nil {
+		return nil, err
+	}
+	if config.Secret, config.SecretFile, err = requiredSecret(getenv, "SECRET"); err != nil {
+		return nil, err
+	}
+	if config.ProxyPort, err = requiredPort(getenv, "PROXY_PORT"); err != nil {
+		return nil, err
+	}
+	if config.PeerPort, err = requiredPort(getenv, "PEER_PORT"); err != nil {
+		return nil, err
+	}
+	if config.HTTPSPort, err = requiredPort(getenv, "HTTPS_PORT"); err != nil {
 		return nil, err
 	}
-	if config.CAName, err = requiredString("CA_NAME"); err != nil {
+	if config.AdminPort, err = optionalPort(getenv, "ADMIN_PORT"); err != nil {
 		return nil, err
 	}
-	if config.CertCANamespace, err = requiredString("CERT_CA_NAMESPACE"); err != nil {
+	if config.AdminToken, config.AdminTokenFile, err = optionalSecret(getenv, "ADMIN_TOKEN"); err != nil {
 		return nil, err
 	}
-	if config.CertCAName, err = requiredString("CERT_CA_NAME"); err != nil {
+	config.ClientSelection = getenv("CLIENT_SELECTION")
+	if config.ClientSelection == "" {
+		config.ClientSelection = ClientSelectionRandom
+	}
+	config.LogLevel = getenv("LOG_LEVEL")
+	config.LogFormat = getenv("LOG_FORMAT")
+	if config.LogFormat == "" {
+		config.LogFormat = LogFormatText
+	}
+	config.TracingExporter = getenv("TRACING_EXPORTER")
+	config.PodName = getenv("POD_NAME")
+	config.PodNamespace = getenv("POD_NAMESPACE")
+	config.EventObject = getenv("EVENT_OBJECT")
+	config.StatusConfigMap = getenv("STATUS_CONFIGMAP")
+	config.APIServices = optionalList(getenv, "APISERVICES")
+	config.TLSSANs = optionalList(getenv, "TLS_SANS")
+	config.TLSIPSANs = optionalList(getenv, "TLS_IP_SANS")
+	config.TLSMinVersion = getenv("TLS_MIN_VERSION")
+	config.TLSCipherSuites = optionalList(getenv, "TLS_CIPHER_SUITES")
+	config.TLSSecret = getenv("TLS_SECRET")
+	config.PeerService = getenv("PEER_SERVICE")
+	if config.CertExpiryDays, err = optionalInt(getenv, "CERT_EXPIRY_DAYS", defaultCertExpiryDays); err != nil {
 		return nil, err
 	}
-	if config.Secret, err = requiredString("SECRET"); err != nil {
+	if config.CertRegenerate, err = optionalBool(getenv, "CERT_REGENERATE", true); err != nil {
 		return nil, err
 	}
-	if config.ProxyPort, err = requiredPort("PROXY_PORT"); err != nil {
+	if config.NoClientEventThreshold, err = optionalDuration(getenv, "NO_CLIENT_EVENT_THRESHOLD", defaultNoClientEventThreshold); err != nil {
 		return nil, err
 	}
-	if config.PeerPort, err = requiredPort("PEER_PORT"); err != nil {
+	if config.APIServiceAvailability, err = optionalBool(getenv, "APISERVICE_AVAILABILITY", false); err != nil {
 		return nil, err
 	}
-	if config.HTTPSPort, err = requiredPort("HTTPS_PORT"); err != nil {
+	if config.TunnelRoutes, err = optionalBool(getenv, "TUNNEL_ROUTES", false); err != nil {
 		return nil, err
 	}
-	config.Debug = len(os.Getenv("DEBUG")) > 0
+	config.Debug = len(getenv("DEBUG")) > 0
 
 	return &config, nil
 }
+
+// Validate checks the configuration for values that would only fail later at
+// runtime, such as out of range ports or invalid Kubernetes object names.
+// All problems found are returned together.
+func (c *Config) Validate() error {
+	var errs []error
+
+	errs = append(errs, validatePort("PROXY_PORT", c.ProxyPort)...)
+	errs = append(errs, validatePort("PEER_PORT", c.PeerPort)...)
+	errs = append(errs, validatePort("HTTPS_PORT", c.HTTPSPort)...)
+	if c.ProxyPort == c.HTTPSPort {
+		errs = append(errs, fmt.Errorf("PROXY_PORT and HTTPS_PORT cannot both listen on port %d", c.ProxyPort))
+	}
+	if c.AdminPort != 0 {
+		errs = append(errs, validatePort("ADMIN_PORT", c.AdminPort)...)
+		if c.AdminPort == c.ProxyPort || c.AdminPort == c.HTTPSPort {
+			errs = append(errs, fmt.Errorf("ADMIN_PORT %d collides with another listener", c.AdminPort))
+		}
+	}
+
+	switch c.ClientSelection {
+	case "", ClientSelectionRandom, ClientSelectionRoundRobin:
+	default:
+		errs = append(errs, fmt.Errorf("CLIENT_SELECTION must be %q or %q, got %q", ClientSelectionRandom, ClientSelectionRoundRobin, c.ClientSelection))
+	}
+	if c.LogLevel != "" {
+		if _, err := logrus.ParseLevel(c.LogLevel); err != nil {
+			errs = append(errs, fmt.Errorf("LOG_LEVEL: %w", err))
+		}
+	}
+	for _, name := range c.APIServices {
+		errs = append(errs, validateName("APISERVICES", name, validation.IsDNS1123Subdomain)...)
+	}
+	if c.APIServiceAvailability && len(c.APIServices) == 0 {
+		errs = append(errs, fmt.Errorf("APISERVICE_AVAILABILITY requires APISERVICES"))
+	}
+	if _, err := eventObject(c); err != nil {
+		errs = append(errs, err)
+	}
+	switch c.TracingExporter {
+	case "", TracingExporterNone, TracingExporterOTLP, TracingExporterStdout:
+	default:
+		errs = append(errs, fmt.Errorf("TRACING_EXPORTER must be %q, %q or %q, got %q", TracingExporterNone, TracingExporterOTLP, TracingExporterStdout, c.TracingExporter))
+	}
+	switch c.LogFormat {
+	case "", LogFormatText, LogFormatJSON:
+	default:
+		errs = append(errs, fmt.Errorf("LOG_FORMAT must be %q or %q, got %q", LogFormatText, LogFormatJSON, c.LogFormat))
+	}
+
+	errs = append(errs, validateSAN("TLS_NAME", c.TLSName)...)
+	for _, san := range c.TLSSANs {
+		errs = append(errs, validateSAN("TLS_SANS", san)...)
+	}
+	for _, ip := range c.TLSIPSANs {
+		if net.ParseIP(ip) == nil {
+			errs = append(errs, fmt.Errorf("TLS_IP_SANS %q is not a valid IP address", ip))
+		}
+	}
+	if _, err := tlsVersion(c.TLSMinVersion); err != nil {
+		errs = append(errs, fmt.Errorf("TLS_MIN_VERSION: %w", err))
+	}
+	if _, err := tlsCipherSuites(c.TLSCipherSuites); err != nil {
+		errs = append(errs, fmt.Errorf("TLS_CIPHER_SUITES: %w", err))
+	}
+	if c.TLSSecret != "" {
+		errs = append(errs, validateName("TLS_SECRET", c.TLSSecret, validation.IsDNS1123Subdomain)...)
+	}
+	if c.PeerService != "" {
+		errs = append(errs, validateName("PEER_SERVICE", c.PeerService, validation.IsDNS1035Label)...)
+		if c.PodName == "" {
+			errs = append(errs, fmt.Errorf("PEER_SERVICE requires POD_NAME"))
+		}
+	}
+	if c.CertExpiryDays < 0 {
+		errs = append(errs, fmt.Errorf("CERT_EXPIRY_DAYS cannot be negative, got %d", c.CertExpiryDays))
+	}
+	errs = append(errs, validateName("CA_NAME", c.CAName, validation.IsDNS1123Subdomain)...)
+	errs = append(errs, validateName("CERT_CA_NAME", c.CertCAName, validation.IsDNS1123Subdomain)...)
+	errs = append(errs, validateName("CERT_CA_NAMESPACE", c.CertCANamespace, validation.IsDNS1123Label)...)
+	if c.StatusConfigMap != "" {
+		errs = append(errs, validateName("STATUS_CONFIGMAP", c.StatusConfigMap, validation.IsDNS1123Subdomain)...)
+	}
+
+	if c.Secret == "" {
+		errs = append(errs, fmt.Errorf("SECRET cannot be empty"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func validatePort(key string, port int) []error {
+	if port <= 0 || port > maxPort {
+		return []error{fmt.Errorf("%s must be between 1 and %d, got %d", key, maxPort, port)}
+	}
+	return nil
+}
+
+func validateSAN(key, value string) []error {
+	if value == "" {
+		return []error{fmt.Errorf("%s cannot be empty", key)}
+	}
+	var msgs []string
+	if strings.HasPrefix(value, "*.") {
+		msgs = validation.IsWildcardDNS1123Subdomain(value)
+	} else {
+		msgs = validation.IsDNS1123Subdomain(value)
+	}
+	if len(msgs) > 0 {
+		return []error{fmt.Errorf("%s %q is not a valid DNS SAN: %s", key, value, strings.Join(msgs, "; "))}
+	}
+	return nil
+}
+
+func validateName(key, value string, validate func(string) []string) []error {
+	if value == "" {
+		return []error{fmt.Errorf("%s cannot be empty", key)}
+	}
+	if msgs := validate(value); len(msgs) > 0 {
+		return []error{fmt.Errorf("%s %q is not a valid Kubernetes name: %s", key, value, strings.Join(msgs, "; "))}
+	}
+	return nil
+}
+
+// level returns the log level the configuration asks for.
+func (c *Config) level() logrus.Level {
+	if c.LogLevel != "" {
+		if level, err := logrus.ParseLevel(c.LogLevel); err == nil {
+			return level
+		}
+	}
+	if c.Debug {
+		return logrus.DebugLevel
+	}
+	return logrus.InfoLevel
+}
+
+// configureLogging applies the configured log level and format to the global
+// logger.
+func (c *Config) configureLogging() {
+	logrus.SetLevel(c.level())
+	if c.LogFormat == LogFormatJSON {
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	} else {
+		logrus.SetFormatter(&logrus.TextFormatter{})
+	}
+}
@@ -2,6 +2,8 @@ package proxy
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -11,9 +13,15 @@ import (
 func TestConfigFromEnvironment(t *testing.T) {
 	keysToSave := []string{
 		"TLS_NAME", "CA_NAME", "CERT_CA_NAMESPACE", "CERT_CA_NAME",
-		"SECRET", "PROXY_PORT", "PEER_PORT", "HTTPS_PORT", "DEBUG",
+		"SECRET", "SECRET_FILE", "ADMIN_TOKEN", "ADMIN_TOKEN_FILE", "PROXY_PORT", "PEER_PORT", "HTTPS_PORT", "DEBUG",
+		"ADMIN_PORT", "CLIENT_SELECTION", "LOG_LEVEL", "LOG_FORMAT", "CONFIG_FILE", "TRACING_EXPORTER",
+		"TLS_SANS", "TLS_IP_SANS", "TLS_MIN_VERSION", "TLS_CIPHER_SUITES", "TLS_SECRET", "CERT_EXPIRY_DAYS", "CERT_REGENERATE",
+		"APISERVICES", "APISERVICE_AVAILABILITY", "TUNNEL_ROUTES",
 	}
 
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(secretFile, []byte("file-secret\n"), 0o600))
+
 	tests := []struct {
 		name        string
 		setupEnv    func(t *testing.T)
@@ -35,15 +43,20 @@ func TestConfigFromEnvironment(t *testing.T) {
 			},
 			expectError: false,
 			expected: &Config{
-				TLSName:         "test-tls",
-				CAName:          "test-ca",
-				CertCANamespace: "test-namespace",
-				CertCAName:      "test-cert-ca",
-				Secret:          "test-secret",
-				ProxyPort:       8080,
-				PeerPort:        8081,
-				HTTPSPort:       8443,
-				Debug:           true,
+				TLSName:                "test-tls",
+				CAName:                 "test-ca",
+				CertCANamespace:        "test-namespace",
+				CertCAName:             "test-cert-ca",
+				Secret:                 "test-secret",
+				ProxyPort:              8080,
+				PeerPort:               8081,
+				HTTPSPort:              8443,
+				Debug:                  true,
+				CertExpiryDays:         10,
+				ClientSelection:        ClientSelectionRandom,
+				LogFormat:              LogFormatText,
+				NoClientEventThreshold: defaultNoClientEventThreshold,
+				CertRegenerate:         true,
 			},
 		},
 		{
@@ -61,17 +74,322 @@ func TestConfigFromEnvironment(t *testing.T) {
 			},
 			expectError: false,
 			expected: &Config{
-				TLSName:         "test-tls",
-				CAName:          "test-ca",
-				CertCANamespace: "test-namespace",
-				CertCAName:      "test-cert-ca",
-				Secret:          "test-secret",
-				ProxyPort:       8080,
-				PeerPort:        8081,
-				HTTPSPort:       8443,
-				Debug:           false,
+				TLSName:                "test-tls",
+				CAName:                 "test-ca",
+				CertCANamespace:        "test-namespace",
+				CertCAName:             "test-cert-ca",
+				Secret:                 "test-secret",
+				ProxyPort:              8080,
+				PeerPort:               8081,
+				HTTPSPort:              8443,
+				Debug:                  false,
+				CertExpiryDays:         10,
+				ClientSelection:        ClientSelectionRandom,
+				LogFormat:              LogFormatText,
+				NoClientEventThreshold: defaultNoClientEventThreshold,
+				CertRegenerate:         true,
+			},
+		},
+		{
+			name: "Success with SECRET_FILE",
+			setupEnv: func(t *testing.T) {
+				t.Setenv("TLS_NAME", "test-tls")
+				t.Setenv("CA_NAME", "test-ca")
+				t.Setenv("CERT_CA_NAMESPACE", "test-namespace")
+				t.Setenv("CERT_CA_NAME", "test-cert-ca")
+				t.Setenv("SECRET_FILE", secretFile)
+				t.Setenv("PROXY_PORT", "8080")
+				t.Setenv("PEER_PORT", "8081")
+				t.Setenv("HTTPS_PORT", "8443")
+			},
+			expectError: false,
+			expected: &Config{
+				TLSName:                "test-tls",
+				CAName:                 "test-ca",
+				CertCANamespace:        "test-namespace",
+				CertCAName:             "test-cert-ca",
+				Secret:                 "file-secret",
+				SecretFile:             secretFile,
+				ProxyPort:              8080,
+				PeerPort:               8081,
+				HTTPSPort:              8443,
+				CertExpiryDays:         10,
+				ClientSelection:        ClientSelectionRandom,
+				LogFormat:              LogFormatText,
+				NoClientEventThreshold: defaultNoClientEventThreshold,
+				CertRegenerate:         true,
+			},
+		},
+		{
+			name: "Success with ADMIN_TOKEN_FILE",
+			setupEnv: func(t *testing.T) {
+				t.Setenv("TLS_NAME", "test-tls")
+				t.Setenv("CA_NAME", "test-ca")
+				t.Setenv("CERT_CA_NAMESPACE", "test-namespace")
+				t.Setenv("CERT_CA_NAME", "test-cert-ca")
+				t.Setenv("SECRET", "test-secret")
+				t.Setenv("ADMIN_TOKEN_FILE", secretFile)
+				t.Setenv("PROXY_PORT", "8080")
+				t.Setenv("PEER_PORT", "8081")
+				t.Setenv("HTTPS_PORT", "8443")
+			},
+			expectError: false,
+			expected: &Config{
+				TLSName:                "test-tls",
+				CAName:                 "test-ca",
+				CertCANamespace:        "test-namespace",
+				CertCAName:             "test-cert-ca",
+				Secret:                 "test-secret",
+				AdminToken:             "file-secret",
+				AdminTokenFile:         secretFile,
+				ProxyPort:              8080,
+				PeerPort:               8081,
+				HTTPSPort:              8443,
+				CertExpiryDays:         10,
+				ClientSelection:        ClientSelectionRandom,
+				LogFormat:              LogFormatText,
+				NoClientEventThreshold: defaultNoClientEventThreshold,
+				CertRegenerate:         true,
+			},
+		},
+		{
+			name: "Success with TLS options",
+			setupEnv: func(t *testing.T) {
+				t.Setenv("TLS_NAME", "test-tls")
+				t.Setenv("CA_NAME", "test-ca")
+				t.Setenv("CERT_CA_NAMESPACE", "test-namespace")
+				t.Setenv("CERT_CA_NAME", "test-cert-ca")
+				t.Setenv("SECRET", "test-secret")
+				t.Setenv("PROXY_PORT", "8080")
+				t.Setenv("PEER_PORT", "8081")
+				t.Setenv("HTTPS_PORT", "8443")
+				t.Setenv("TLS_SANS", "proxy.example.com, proxy.local")
+				t.Setenv("TLS_IP_SANS", "10.0.0.1")
+				t.Setenv("TLS_MIN_VERSION", "1.3")
+				t.Setenv("CERT_EXPIRY_DAYS", "30")
+				t.Setenv("CERT_REGENERATE", "false")
+			},
+			expectError: false,
+			expected: &Config{
+				TLSName:                "test-tls",
+				CAName:                 "test-ca",
+				CertCANamespace:        "test-namespace",
+				CertCAName:             "test-cert-ca",
+				Secret:                 "test-secret",
+				ProxyPort:              8080,
+				PeerPort:               8081,
+				HTTPSPort:              8443,
+				TLSSANs:                []string{"proxy.example.com", "proxy.local"},
+				TLSIPSANs:              []string{"10.0.0.1"},
+				TLSMinVersion:          "1.3",
+				CertExpiryDays:         30,
+				ClientSelection:        ClientSelectionRandom,
+				LogFormat:              LogFormatText,
+				NoClientEventThreshold: defaultNoClientEventThreshold,
+			},
+		},
+		{
+			name: "Success with APISERVICE_AVAILABILITY false",
+			setupEnv: func(t *testing.T) {
+				t.Setenv("TLS_NAME", "test-tls")
+				t.Setenv("CA_NAME", "test-ca")
+				t.Setenv("CERT_CA_NAMESPACE", "test-namespace")
+				t.Setenv("CERT_CA_NAME", "test-cert-ca")
+				t.Setenv("SECRET", "test-secret")
+				t.Setenv("PROXY_PORT", "8080")
+				t.Setenv("PEER_PORT", "8081")
+				t.Setenv("HTTPS_PORT", "8443")
+				t.Setenv("APISERVICES", "v1.ext.cattle.io")
+				t.Setenv("APISERVICE_AVAILABILITY", "false")
+			},
+			expectError: false,
+			expected: &Config{
+				TLSName:                "test-tls",
+				CAName:                 "test-ca",
+				CertCANamespace:        "test-namespace",
+				CertCAName:             "test-cert-ca",
+				Secret:                 "test-secret",
+				ProxyPort:              8080,
+				PeerPort:               8081,
+				HTTPSPort:              8443,
+				APIServices:            []string{"v1.ext.cattle.io"},
+				APIServiceAvailability: false,
+				Debug:                  false,
+				CertExpiryDays:         10,
+				ClientSelection:        ClientSelectionRandom,
+				LogFormat:              LogFormatText,
+				NoClientEventThreshold: defaultNoClientEventThreshold,
+				CertRegenerate:         true,
+			},
+		},
+		{
+			name: "Success with TUNNEL_ROUTES",
+			setupEnv: func(t *testing.T) {
+				t.Setenv("TLS_NAME", "test-tls")
+				t.Setenv("CA_NAME", "test-ca")
+				t.Setenv("CERT_CA_NAMESPACE", "test-namespace")
+				t.Setenv("CERT_CA_NAME", "test-cert-ca")
+				t.Setenv("SECRET", "test-secret")
+				t.Setenv("PROXY_PORT", "8080")
+				t.Setenv("PEER_PORT", "8081")
+				t.Setenv("HTTPS_PORT", "8443")
+				t.Setenv("TUNNEL_ROUTES", "true")
+			},
+			expectError: false,
+			expected: &Config{
+				TLSName:                "test-tls",
+				CAName:                 "test-ca",
+				CertCANamespace:        "test-namespace",
+				CertCAName:             "test-cert-ca",
+				Secret:                 "test-secret",
+				ProxyPort:              8080,
+				PeerPort:               8081,
+				HTTPSPort:              8443,
+				TunnelRoutes:           true,
+				CertExpiryDays:         10,
+				ClientSelection:        ClientSelectionRandom,
+				LogFormat:              LogFormatText,
+				NoClientEventThreshold: defaultNoClientEventThreshold,
+				CertRegenerate:         true,
+			},
+		},
+		{
+			name: "Success with TUNNEL_ROUTES false",
+			setupEnv: func(t *testing.T) {
+				t.Setenv("TLS_NAME", "test-tls")
+				t.Setenv("CA_NAME", "test-ca")
+				t.Setenv("CERT_CA_NAMESPACE", "test-namespace")
+				t.Setenv("CERT_CA_NAME", "test-cert-ca")
+				t.Setenv("SECRET", "test-secret")
+				t.Setenv("PROXY_PORT", "8080")
+				t.Setenv("PEER_PORT", "8081")
+				t.Setenv("HTTPS_PORT", "8443")
+				t.Setenv("TUNNEL_ROUTES", "false")
+			},
+			expectError: false,
+			expected: &Config{
+				TLSName:                "test-tls",
+				CAName:                 "test-ca",
+				CertCANamespace:        "test-namespace",
+				CertCAName:             "test-cert-ca",
+				Secret:                 "test-secret",
+				ProxyPort:              8080,
+				PeerPort:               8081,
+				HTTPSPort:              8443,
+				TunnelRoutes:           false,
+				CertExpiryDays:         10,
+				ClientSelection:        ClientSelectionRandom,
+				LogFormat:              LogFormatText,
+				NoClientEventThreshold: defaultNoClientEventThreshold,
+				CertRegenerate:         true,
+			},
+		},
+		{
+			name: "Invalid TUNNEL_ROUTES",
+			setupEnv: func(t *testing.T) {
+				t.Setenv("TLS_NAME", "test-tls")
+				t.Setenv("CA_NAME", "test-ca")
+				t.Setenv("CERT_CA_NAMESPACE", "test-namespace")
+				t.Setenv("CERT_CA_NAME", "test-cert-ca")
+				t.Setenv("SECRET", "test-secret")
+				t.Setenv("PROXY_PORT", "8080")
+				t.Setenv("PEER_PORT", "8081")
+				t.Setenv("HTTPS_PORT", "8443")
+				t.Setenv("TUNNEL_ROUTES", "on")
+			},
+			expectError: true,
+		},
+		{
+			name: "Success with any DEBUG value",
+			setupEnv: func(t *testing.T) {
+				t.Setenv("TLS_NAME", "test-tls")
+				t.Setenv("CA_NAME", "test-ca")
+				t.Setenv("CERT_CA_NAMESPACE", "test-namespace")
+				t.Setenv("CERT_CA_NAME", "test-cert-ca")
+				t.Setenv("SECRET", "test-secret")
+				t.Setenv("PROXY_PORT", "8080")
+				t.Setenv("PEER_PORT", "8081")
+				t.Setenv("HTTPS_PORT", "8443")
+				t.Setenv("DEBUG", "yes")
+			},
+			expectError: false,
+			expected: &Config{
+				TLSName:                "test-tls",
+				CAName:                 "test-ca",
+				CertCANamespace:        "test-namespace",
+				CertCAName:             "test-cert-ca",
+				Secret:                 "test-secret",
+				ProxyPort:              8080,
+				PeerPort:               8081,
+				HTTPSPort:              8443,
+				Debug:                  true,
+				CertExpiryDays:         10,
+				ClientSelection:        ClientSelectionRandom,
+				LogFormat:              LogFormatText,
+				NoClientEventThreshold: defaultNoClientEventThreshold,
+				CertRegenerate:         true,
 			},
 		},
+		{
+			name: "Invalid CERT_REGENERATE",
+			setupEnv: func(t *testing.T) {
+				t.Setenv("TLS_NAME", "test-tls")
+				t.Setenv("CA_NAME", "test-ca")
+				t.Setenv("CERT_CA_NAMESPACE", "test-namespace")
+				t.Setenv("CERT_CA_NAME", "test-cert-ca")
+				t.Setenv("SECRET", "test-secret")
+				t.Setenv("PROXY_PORT", "8080")
+				t.Setenv("PEER_PORT", "8081")
+				t.Setenv("HTTPS_PORT", "8443")
+				t.Setenv("CERT_REGENERATE", "sometimes")
+			},
+			expectError: true,
+		},
+		{
+			name: "Both SECRET and SECRET_FILE",
+			setupEnv: func(t *testing.T) {
+				t.Setenv("TLS_NAME", "test-tls")
+				t.Setenv("CA_NAME", "test-ca")
+				t.Setenv("CERT_CA_NAMESPACE", "test-namespace")
+				t.Setenv("CERT_CA_NAME", "test-cert-ca")
+				t.Setenv("SECRET", "test-secret")
+				t.Setenv("SECRET_FILE", secretFile)
+				t.Setenv("PROXY_PORT", "8080")
+				t.Setenv("PEER_PORT", "8081")
+				t.Setenv("HTTPS_PORT", "8443")
+			},
+			expectError: true,
+		},
+		{
+			name: "Both ADMIN_TOKEN and ADMIN_TOKEN_FILE",
+			setupEnv: func(t *testing.T) {
+				t.Setenv("TLS_NAME", "test-tls")
+				t.Setenv("CA_NAME", "test-ca")
+				t.Setenv("CERT_CA_NAMESPACE", "test-namespace")
+				t.Setenv("CERT_CA_NAME", "test-cert-ca")
+				t.Setenv("SECRET", "test-secret")
+				t.Setenv("ADMIN_TOKEN", "test-token")
+				t.Setenv("ADMIN_TOKEN_FILE", secretFile)
+				t.Setenv("PROXY_PORT", "8080")
+				t.Setenv("PEER_PORT", "8081")
+				t.Setenv("HTTPS_PORT", "8443")
+			},
+			expectError: true,
+		},
+		{
+			name: "Missing SECRET_FILE",
+			setupEnv: func(t *testing.T) {
+				t.Setenv("TLS_NAME", "test-tls")
+				t.Setenv("CA_NAME", "test-ca")
+				t.Setenv("CERT_CA_NAMESPACE", "test-namespace")
+				t.Setenv("CERT_CA_NAME", "test-cert-ca")
+				t.Setenv("SECRET_FILE", filepath.Join(t.TempDir(), "missing"))
+				t.Setenv("PROXY_PORT", "8080")
+				t.Setenv("PEER_PORT", "8081")
+				t.Setenv("HTTPS_PORT", "8443")
+			},
+			expectError: true,
+		},
 		{
 			name: "Missing TLS_NAME",
 			setupEnv: func(t *testing.T) {
@@ -113,6 +431,20 @@ func TestConfigFromEnvironment(t *testing.T) {
 			},
 			expectError: true,
 		},
+		{
+			name: "HTTPS_PORT collides with PROXY_PORT",
+			setupEnv: func(t *testing.T) {
+				t.Setenv("TLS_NAME", "test-tls")
+				t.Setenv("CA_NAME", "test-ca")
+				t.Setenv("CERT_CA_NAMESPACE", "test-namespace")
+				t.Setenv("CERT_CA_NAME", "test-cert-ca")
+				t.Setenv("SECRET", "test-secret")
+				t.Setenv("PROXY_PORT", "8080")
+				t.Setenv("PEER_PORT", "8081")
+				t.Setenv("HTTPS_PORT", "8080") // Same listener as PROXY_PORT
+			},
+			expectError: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -131,16 +463,157 @@ func TestConfigFromEnvironment(t *testing.T) {
 			} else {
 				require.NoError(t, err, "Did not expect an error but got: %v", err)
 				require.NotNil(t, config, "Expected config but got nil")
-				assert.Equal(t, tt.expected.TLSName, config.TLSName, "TLSName mismatch")
-				assert.Equal(t, tt.expected.CAName, config.CAName, "CAName mismatch")
-				assert.Equal(t, tt.expected.CertCANamespace, config.CertCANamespace, "CertCANamespace mismatch")
-				assert.Equal(t, tt.expected.CertCAName, config.CertCAName, "CertCAName mismatch")
-				assert.Equal(t, tt.expected.Secret, config.Secret, "Secret mismatch")
-				assert.Equal(t, tt.expected.ProxyPort, config.ProxyPort, "ProxyPort mismatch")
-				assert.Equal(t, tt.expected.PeerPort, config.PeerPort, "PeerPort mismatch")
-				assert.Equal(t, tt.expected.HTTPSPort, config.HTTPSPort, "HTTPSPort mismatch")
-				assert.Equal(t, tt.expected.Debug, config.Debug, "Debug mismatch")
+				assert.Equal(t, tt.expected, config)
+			}
+		})
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	validConfig := func() *Config {
+		return &Config{
+			TLSName:         "remotedialer-proxy.cattle-system.svc",
+			CAName:          "test-ca",
+			CertCANamespace: "test-namespace",
+			CertCAName:      "test-cert-ca",
+			Secret:          "test-secret",
+			ProxyPort:       6666,
+			PeerPort:        6666,
+			HTTPSPort:       5555,
+		}
+	}
+
+	tests := []struct {
+		name       string
+		mutate     func(c *Config)
+		wantErrors []string
+	}{
+		{
+			name:   "Valid config",
+			mutate: func(c *Config) {},
+		},
+		{
+			name:   "Wildcard TLS_NAME",
+			mutate: func(c *Config) { c.TLSName = "*.example.com" },
+		},
+		{
+			name:       "PROXY_PORT out of range",
+			mutate:     func(c *Config) { c.ProxyPort = 70000 },
+			wantErrors: []string{"PROXY_PORT must be between 1 and 65535"},
+		},
+		{
+			name:       "PEER_PORT negative",
+			mutate:     func(c *Config) { c.PeerPort = -1 },
+			wantErrors: []string{"PEER_PORT must be between 1 and 65535"},
+		},
+		{
+			name:       "HTTPS_PORT zero",
+			mutate:     func(c *Config) { c.HTTPSPort = 0 },
+			wantErrors: []string{"HTTPS_PORT must be between 1 and 65535"},
+		},
+		{
+			name:       "Listener port collision",
+			mutate:     func(c *Config) { c.HTTPSPort = c.ProxyPort },
+			wantErrors: []string{"PROXY_PORT and HTTPS_PORT cannot both listen on port 6666"},
+		},
+		{
+			name:       "Invalid TLS_NAME",
+			mutate:     func(c *Config) { c.TLSName = "not a dns name" },
+			wantErrors: []string{"TLS_NAME \"not a dns name\" is not a valid DNS SAN"},
+		},
+		{
+			name:       "Invalid CA_NAME",
+			mutate:     func(c *Config) { c.CAName = "Test_CA" },
+			wantErrors: []string{"CA_NAME \"Test_CA\" is not a valid Kubernetes name"},
+		},
+		{
+			name:       "Invalid CERT_CA_NAMESPACE",
+			mutate:     func(c *Config) { c.CertCANamespace = "test.namespace" },
+			wantErrors: []string{"CERT_CA_NAMESPACE \"test.namespace\" is not a valid Kubernetes name"},
+		},
+		{
+			name:       "Empty CERT_CA_NAME",
+			mutate:     func(c *Config) { c.CertCAName = "" },
+			wantErrors: []string{"CERT_CA_NAME cannot be empty"},
+		},
+		{
+			name:       "Invalid CLIENT_SELECTION",
+			mutate:     func(c *Config) { c.ClientSelection = "first" },
+			wantErrors: []string{"CLIENT_SELECTION must be"},
+		},
+		{
+			name:       "ADMIN_PORT collides with PROXY_PORT",
+			mutate:     func(c *Config) { c.AdminPort = c.ProxyPort },
+			wantErrors: []string{"ADMIN_PORT 6666 collides with another listener"},
+		},
+		{
+			name:       "Invalid LOG_LEVEL",
+			mutate:     func(c *Config) { c.LogLevel = "loud" },
+			wantErrors: []string{"LOG_LEVEL"},
+		},
+		{
+			name:       "Invalid LOG_FORMAT",
+			mutate:     func(c *Config) { c.LogFormat = "xml" },
+			wantErrors: []string{"LOG_FORMAT must be"},
+		},
+		{
+			name: "Valid TLS options",
+			mutate: func(c *Config) {
+				c.TLSSANs = []string{"proxy.example.com", "*.proxy.example.com"}
+				c.TLSIPSANs = []string{"10.0.0.1", "fd00::1"}
+				c.TLSMinVersion = "1.3"
+				c.TLSCipherSuites = []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}
+				c.TLSSecret = "proxy-tls"
+			},
+		},
+		{
+			name: "Invalid TLS options",
+			mutate: func(c *Config) {
+				c.TLSSANs = []string{"bad_san"}
+				c.TLSIPSANs = []string{"10.0.0.300"}
+				c.TLSMinVersion = "1.0"
+				c.TLSCipherSuites = []string{"TLS_RSA_WITH_RC4_128_SHA"}
+				c.CertExpiryDays = -1
+			},
+			wantErrors: []string{
+				"TLS_SANS \"bad_san\" is not a valid DNS SAN",
+				"TLS_IP_SANS \"10.0.0.300\" is not a valid IP address",
+				"TLS_MIN_VERSION: unsupported TLS version \"1.0\"",
+				"TLS_CIPHER_SUITES: unknown or insecure cipher suite \"TLS_RSA_WITH_RC4_128_SHA\"",
+				"CERT_EXPIRY_DAYS cannot be negative",
+			},
+		},
+		{
+			name: "Multiple problems reported together",
+			mutate: func(c *Config) {
+				c.ProxyPort = 70000
+				c.TLSName = "bad_name"
+				c.CertCANamespace = ""
+			},
+			wantErrors: []string{
+				"PROXY_PORT must be between 1 and 65535",
+				"TLS_NAME \"bad_name\" is not a valid DNS SAN",
+				"CERT_CA_NAMESPACE cannot be empty",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			tt.mutate(cfg)
+
+			err := cfg.Validate()
+			if len(tt.wantErrors) == 0 {
+				require.NoError(t, err)
+				return
+			}
+
+			require.Error(t, err)
+			for _, want := range tt.wantErrors {
+				assert.Contains(t, err.Error(), want)
 			}
+			assert.Len(t, strings.Split(err.Error(), "\n"), len(tt.wantErrors), "unexpected number of errors: %v", err)
 		})
 	}
 }
@@ -0,0 +1,210 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	eventReasonClientConnected        = "TunnelClientConnected"
+	eventReasonClientDisconnected     = "TunnelClientDisconnected"
+	eventReasonAuthenticationFailed   = "TunnelAuthenticationFailed"
+	eventReasonCertificateRegenerated = "CertificateRegenerated"
+	eventReasonNoClientAvailable      = "NoTunnelClientAvailable"
+
+	authFailureThreshold = 5
+	authFailureWindow    = time.Minute
+
+	defaultNoClientEventThreshold = time.Minute
+
+	// events about the same object are limited to a burst of eventBurst,
+	// refilled at eventQPS
+	eventBurst = 10
+	eventQPS   = 1.0 / 30
+)
+
+// newTunnelEventsFromConfig returns the events recorder for the proxy, or nil
+// when no object to record events on is configured.
+func newTunnelEventsFromConfig(ctx context.Context, cfg *Config, restConfig *rest.Config) (*tunnelEvents, error) {
+	object, err := eventObject(cfg)
+	if err != nil || object == nil {
+		return nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("build events client failed: %w", err)
+	}
+
+	broadcaster := record.NewBroadcaster(
+		record.WithContext(ctx),
+		record.WithCorrelatorOptions(record.CorrelatorOptions{
+			BurstSize: eventBurst,
+			QPS:       eventQPS,
+		}),
+	)
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "remotedialer-proxy"})
+
+	logrus.Infof("recording events on %s %s/%s", object.Kind, object.Namespace, object.Name)
+	return newTunnelEvents(recorder, object, cfg.NoClientEventThreshold), nil
+}
+
+// podNamespace returns the namespace the proxy Pod runs in.
+func podNamespace(cfg *Config) string {
+	if cfg.PodNamespace != "" {
+		return cfg.PodNamespace
+	}
+	return cfg.CertCANamespace
+}
+
+// eventObject returns the object events are recorded on: the configured
+// EventObject, the proxy Pod when running in one, or nil to disable events.
+func eventObject(cfg *Config) (*corev1.ObjectReference, error) {
+	if cfg.EventObject != "" {
+		kind, name, ok := strings.Cut(cfg.EventObject, "/")
+		if !ok || kind == "" || name == "" {
+			return nil, fmt.Errorf("EVENT_OBJECT must be in the form Kind/name, got %q", cfg.EventObject)
+		}
+		return &corev1.ObjectReference{
+			Kind:      kind,
+			Name:      name,
+			Namespace: cfg.CertCANamespace,
+		}, nil
+	}
+	if cfg.PodName != "" {
+		return &corev1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "Pod",
+			Name:       cfg.PodName,
+			Namespace:  podNamespace(cfg),
+		}, nil
+	}
+	return nil, nil
+}
+
+// tunnelEvents records Kubernetes Events about the tunnel so that operators
+// find them with kubectl get events. The broadcaster behind the recorder
+// rate-limits and aggregates repeated events per object.
+type tunnelEvents struct {
+	recorder          record.EventRecorder
+	object            *corev1.ObjectReference
+	noClientThreshold time.Duration
+
+	mu               sync.Mutex
+	authFailures     []time.Time
+	noClientReported bool
+	certificate      []byte
+}
+
+func newTunnelEvents(recorder record.EventRecorder, object *corev1.ObjectReference, noClientThreshold time.Duration) *tunnelEvents {
+	if noClientThreshold <= 0 {
+		noClientThreshold = defaultNoClientEventThreshold
+	}
+	return &tunnelEvents{
+		recorder:          recorder,
+		object:            object,
+		noClientThreshold: noClientThreshold,
+	}
+}
+
+// clientChanged records clients connecting to and disconnecting from this
+// replica. Clients held by peers are reported by the peer holding them.
+func (e *tunnelEvents) clientChanged(event clientEvent) {
+	if event.Peer {
+		return
+	}
+	switch event.Type {
+	case clientConnected:
+		e.mu.Lock()
+		e.noClientReported = false
+		e.mu.Unlock()
+		e.recorder.Eventf(e.object, corev1.EventTypeNormal, eventReasonClientConnected, "Tunnel client %s connected", event.ClientID)
+	case clientDisconnected:
+		e.recorder.Eventf(e.object, corev1.EventTypeNormal, eventReasonClientDisconnected, "Tunnel client %s disconnected", event.ClientID)
+	}
+}
+
+// authFailed records a failed tunnel authentication and emits a warning once
+// authFailureThreshold failures happened within authFailureWindow.
+func (e *tunnelEvents) authFailed(now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	recent := e.authFailures[:0]
+	for _, t := range e.authFailures {
+		if now.Sub(t) < authFailureWindow {
+			recent = append(recent, t)
+		}
+	}
+	e.authFailures = append(recent, now)
+
+	if len(e.authFailures) >= authFailureThreshold {
+		e.recorder.Eventf(e.object, corev1.EventTypeWarning, eventReasonAuthenticationFailed,
+			"%d failed tunnel authentication attempts in the last %s", len(e.authFailures), authFailureWindow)
+		e.authFailures = nil
+	}
+}
+
+// certificateChanged emits an event when the serving certificate differs from
+// the one seen before. The first certificate seen is not reported.
+func (e *tunnelEvents) certificateChanged(secret *corev1.Secret) {
+	if secret == nil {
+		return
+	}
+	cert := secret.Data[corev1.TLSCertKey]
+
+	e.mu.Lock()
+	previous := e.certificate
+	e.certificate = cert
+	e.mu.Unlock()
+
+	if previous != nil && !bytes.Equal(previous, cert) {
+		e.recorder.Eventf(e.object, corev1.EventTypeNormal, eventReasonCertificateRegenerated,
+			"Serving certificate in secret %s/%s was regenerated", secret.Namespace, secret.Name)
+	}
+}
+
+// checkNoClient emits a warning once per outage when no client has been
+// connected, to this replica or a peer, for longer than the threshold.
+func (e *tunnelEvents) checkNoClient(idleSince, now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if idleSince.IsZero() {
+		e.noClientReported = false
+		return
+	}
+	if now.Sub(idleSince) < e.noClientThreshold || e.noClientReported {
+		return
+	}
+	e.noClientReported = true
+	logrus.Warnf("no tunnel client connected since %s", idleSince.Format(time.RFC3339))
+	e.recorder.Eventf(e.object, corev1.EventTypeWarning, eventReasonNoClientAvailable,
+		"No tunnel client connected for %s", now.Sub(idleSince).Round(time.Second))
+}
+
+func (e *tunnelEvents) watchNoClient(ctx context.Context, clients *clientWatcher, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			e.checkNoClient(clients.IdleSince(), now)
+		}
+	}
+}
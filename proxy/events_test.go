@@ -0,0 +1,175 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rancher/remotedialer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func drainEvents(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestTunnelEvents(t *testing.T) {
+	recorder := record.NewFakeRecorder(100)
+	object := &corev1.ObjectReference{Kind: "Pod", Name: "proxy", Namespace: "test"}
+	events := newTunnelEvents(recorder, object, time.Minute)
+	now := time.Now()
+
+	t.Run("client connect and disconnect", func(t *testing.T) {
+		events.clientChanged(clientEvent{Type: clientConnected, ClientID: "a", Time: now})
+		events.clientChanged(clientEvent{Type: clientDisconnected, ClientID: "a", Time: now})
+		assert.Equal(t, []string{
+			"Normal TunnelClientConnected Tunnel client a connected",
+			"Normal TunnelClientDisconnected Tunnel client a disconnected",
+		}, drainEvents(recorder))
+	})
+
+	t.Run("repeated authentication failures", func(t *testing.T) {
+		for i := 0; i < authFailureThreshold-1; i++ {
+			events.authFailed(now)
+		}
+		assert.Empty(t, drainEvents(recorder))
+
+		// Failures outside the window do not count
+		events.authFailed(now.Add(2 * authFailureWindow))
+		assert.Empty(t, drainEvents(recorder))
+
+		for i := 0; i < authFailureThreshold-1; i++ {
+			events.authFailed(now.Add(2 * authFailureWindow))
+		}
+		got := drainEvents(recorder)
+		require.Len(t, got, 1)
+		assert.Contains(t, got[0], "Warning TunnelAuthenticationFailed")
+	})
+
+	t.Run("certificate regenerated", func(t *testing.T) {
+		secret := &corev1.Secret{Data: map[string][]byte{corev1.TLSCertKey: []byte("first")}}
+		events.certificateChanged(secret)
+		events.certificateChanged(secret)
+		assert.Empty(t, drainEvents(recorder))
+
+		events.certificateChanged(&corev1.Secret{Data: map[string][]byte{corev1.TLSCertKey: []byte("second")}})
+		got := drainEvents(recorder)
+		require.Len(t, got, 1)
+		assert.Contains(t, got[0], "Normal CertificateRegenerated")
+	})
+
+	t.Run("no client available", func(t *testing.T) {
+		events.checkNoClient(time.Time{}, now)
+		events.checkNoClient(now, now.Add(30*time.Second))
+		assert.Empty(t, drainEvents(recorder))
+
+		events.checkNoClient(now, now.Add(2*time.Minute))
+		events.checkNoClient(now, now.Add(3*time.Minute))
+		got := drainEvents(recorder)
+		require.Len(t, got, 1, "expected a single warning per outage")
+		assert.Contains(t, got[0], "Warning NoTunnelClientAvailable")
+
+		events.clientChanged(clientEvent{Type: clientConnected, ClientID: "a", Time: now})
+		drainEvents(recorder)
+		events.checkNoClient(now, now.Add(5*time.Minute))
+		assert.Len(t, drainEvents(recorder), 1, "expected a new warning after a client reconnected")
+
+		// A client connecting to a peer ends the outage as well
+		events.checkNoClient(time.Time{}, now.Add(6*time.Minute))
+		events.checkNoClient(now.Add(7*time.Minute), now.Add(9*time.Minute))
+		assert.Len(t, drainEvents(recorder), 1, "expected a new warning after a client connected to a peer")
+	})
+
+	t.Run("clients of peers", func(t *testing.T) {
+		events.clientChanged(clientEvent{Type: clientConnected, ClientID: "b", Time: now, Peer: true})
+		events.clientChanged(clientEvent{Type: clientDisconnected, ClientID: "b", Time: now, Peer: true})
+		assert.Empty(t, drainEvents(recorder), "the peer holding the client reports it")
+	})
+}
+
+func TestClientWatcher(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server, _ := startTestTunnel(ctx, t)
+
+	watcher := newClientWatcher(server)
+	var got []clientEvent
+	watcher.OnChange(func(event clientEvent) {
+		got = append(got, event)
+	})
+
+	now := time.Now()
+	watcher.sync(now)
+	require.Len(t, got, 1)
+	assert.Equal(t, clientConnected, got[0].Type)
+	assert.Equal(t, "client-id", got[0].ClientID)
+	assert.Equal(t, map[string]time.Time{"client-id": now}, watcher.Clients())
+	assert.True(t, watcher.IdleSince().IsZero())
+
+	// No change, no event
+	watcher.sync(now.Add(time.Second))
+	assert.Len(t, got, 1)
+
+	cancel()
+	require.Eventually(t, func() bool {
+		return len(server.ListClients()) == 0
+	}, 2*time.Second, 10*time.Millisecond)
+
+	later := now.Add(2 * time.Second)
+	watcher.sync(later)
+	require.Len(t, got, 2)
+	assert.Equal(t, clientDisconnected, got[1].Type)
+	assert.Equal(t, later, watcher.IdleSince())
+}
+
+func TestClientWatcherPeers(t *testing.T) {
+	server, _ := startTestTunnel(context.Background(), t)
+
+	var peerClients []string
+	watcher := newClientWatcher(server)
+	watcher.peers = func() []string { return peerClients }
+	var got []clientEvent
+	watcher.OnChange(func(event clientEvent) {
+		got = append(got, event)
+	})
+
+	// A client held locally and by a peer is only reported once
+	now := time.Now()
+	peerClients = []string{"client-id", "peer-client"}
+	watcher.sync(now)
+	assert.Equal(t, []clientEvent{
+		{Type: clientConnected, ClientID: "client-id", Time: now},
+		{Type: clientConnected, ClientID: "peer-client", Time: now, Peer: true},
+	}, got)
+	assert.Equal(t, map[string]time.Time{"client-id": now}, watcher.Clients())
+	assert.Equal(t, map[string]time.Time{"client-id": now, "peer-client": now}, watcher.AllClients())
+
+	// Replicas without a client of their own are not idle while a peer holds one
+	watcher.server = remotedialer.New(nil, remotedialer.DefaultErrorWriter)
+	got = nil
+	later := now.Add(time.Second)
+	peerClients = []string{"peer-client"}
+	watcher.sync(later)
+	assert.Equal(t, []clientEvent{{Type: clientDisconnected, ClientID: "client-id", Time: later}}, got)
+	assert.True(t, watcher.IdleSince().IsZero())
+	assert.Empty(t, watcher.Clients())
+	assert.Equal(t, map[string]time.Time{"peer-client": now}, watcher.AllClients())
+
+	got = nil
+	peerClients = nil
+	watcher.sync(later.Add(time.Second))
+	assert.Equal(t, []clientEvent{{Type: clientDisconnected, ClientID: "peer-client", Time: later.Add(time.Second), Peer: true}}, got)
+	assert.Equal(t, later.Add(time.Second), watcher.IdleSince())
+}
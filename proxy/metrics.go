@@ -0,0 +1,32 @@
+package proxy
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	metricsNamespace = "remotedialer_proxy"
+
+	reloadResultSuccess = "success"
+	reloadResultFailure = "failure"
+)
+
+var (
+	configReloads = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "config_reloads_total",
+			Help:      "Total count of configuration reloads by result",
+		},
+		[]string{"result"},
+	)
+
+	configLastReloadSuccess = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "config_last_reload_success_timestamp_seconds",
+			Help:      "Timestamp of the last successful configuration reload",
+		},
+	)
+)
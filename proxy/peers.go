@@ -0,0 +1,311 @@
+package proxy
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rancher/remotedialer"
+	"github.com/sirupsen/logrus"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	peerSyncInterval = 5 * time.Second
+	peerClientsPath  = "/peer/clients"
+
+	peerTokenContext = "remotedialer-proxy peer"
+)
+
+type endpointSliceLister interface {
+	List(namespace string, selector labels.Selector) ([]*discoveryv1.EndpointSlice, error)
+}
+
+// peerManager lets the proxy replicas behind PeerService dial through each
+// other's tunnel clients. Replicas are found through the Service's
+// EndpointSlices and connected with remotedialer peering; the IDs of the
+// clients each peer holds are polled from its peerClientsPath, since
+// remotedialer only lists local clients.
+type peerManager struct {
+	server      *remotedialer.Server
+	endpoints   endpointSliceLister
+	secret      *secretValue
+	token       string
+	secrets     secretGetter
+	namespace   string
+	service     string
+	port        int
+	caNamespace string
+	caName      string
+	caKey       string
+	serverName  string
+	client      *http.Client
+
+	mu          sync.Mutex
+	peers       map[string]string   // peer ID (pod name) to its host:port
+	clients     map[string][]string // peer ID to the clients it holds
+	staleWarned bool
+}
+
+// newPeerManager authenticates peers with a token derived from secret. It must
+// be called before server handles requests: remotedialer reads the PeerID and
+// PeerToken fields from its own goroutines without locking, so the token is
+// fixed for the lifetime of the server. Peer clients are listed over TLS
+// verified against the CA of the serving certificate, read from secrets.
+func newPeerManager(server *remotedialer.Server, endpoints endpointSliceLister, secrets secretGetter, cfg *Config, secret *secretValue) *peerManager {
+	token := peerToken(secret.Get())
+	server.PeerID = cfg.PodName
+	server.PeerToken = token
+	m := &peerManager{
+		server:      server,
+		endpoints:   endpoints,
+		secret:      secret,
+		token:       token,
+		secrets:     secrets,
+		namespace:   podNamespace(cfg),
+		service:     cfg.PeerService,
+		port:        cfg.HTTPSPort,
+		caNamespace: cfg.CertCANamespace,
+		serverName:  cfg.TLSName,
+		peers:       map[string]string{},
+		clients:     map[string][]string{},
+	}
+	m.caName, m.caKey = caSecret(cfg)
+	m.client = &http.Client{
+		Timeout:   peerSyncInterval,
+		Transport: &http.Transport{DialTLSContext: m.dialTLS},
+	}
+	return m
+}
+
+// peerToken derives the credential replicas authenticate to each other with
+// from the tunnel secret. remotedialer dials peers without verifying their
+// certificate, so the secret itself is never sent to them: an intercepted
+// peer token does not let anyone connect as a tunnel client.
+func peerToken(secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(peerTokenContext))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// dialTLS dials a peer, which is addressed by Pod IP, verifying that it serves
+// a certificate for TLSName issued by the current CA.
+func (m *peerManager) dialTLS(ctx context.Context, network, addr string) (net.Conn, error) {
+	secret, err := m.secrets.Get(m.caNamespace, m.caName)
+	if err != nil {
+		return nil, fmt.Errorf("CA secret %s/%s: %w", m.caNamespace, m.caName, err)
+	}
+	rootCAs := x509.NewCertPool()
+	if !rootCAs.AppendCertsFromPEM(secret.Data[m.caKey]) {
+		return nil, fmt.Errorf("CA secret %s/%s has no certificate in %s field", m.caNamespace, m.caName, m.caKey)
+	}
+
+	dialer := &tls.Dialer{Config: &tls.Config{
+		RootCAs:    rootCAs,
+		ServerName: m.serverName,
+	}}
+	return dialer.DialContext(ctx, network, addr)
+}
+
+// OnEndpointSliceChange is an EndpointSlice OnChange handler adding and
+// removing remotedialer peers as proxy replicas become ready or go away.
+func (m *peerManager) OnEndpointSliceChange(_ string, slice *discoveryv1.EndpointSlice) (*discoveryv1.EndpointSlice, error) {
+	if slice != nil && (slice.Namespace != m.namespace || slice.Labels[discoveryv1.LabelServiceName] != m.service) {
+		return slice, nil
+	}
+
+	endpointSlices, err := m.endpoints.List(m.namespace, labels.SelectorFromSet(labels.Set{discoveryv1.LabelServiceName: m.service}))
+	if err != nil {
+		return slice, err
+	}
+	m.sync(m.readyPeers(endpointSlices))
+	return slice, nil
+}
+
+// readyPeers returns the address of every ready replica but this one, by Pod
+// name.
+func (m *peerManager) readyPeers(endpointSlices []*discoveryv1.EndpointSlice) map[string]string {
+	peers := map[string]string{}
+	for _, slice := range endpointSlices {
+		for _, endpoint := range slice.Endpoints {
+			if endpoint.TargetRef == nil || endpoint.TargetRef.Kind != "Pod" || endpoint.TargetRef.Name == m.server.PeerID {
+				continue
+			}
+			if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+				continue
+			}
+			if len(endpoint.Addresses) == 0 {
+				continue
+			}
+			peers[endpoint.TargetRef.Name] = net.JoinHostPort(endpoint.Addresses[0], strconv.Itoa(m.port))
+		}
+	}
+	return peers
+}
+
+func (m *peerManager) sync(peers map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, host := range peers {
+		if m.peers[id] != host {
+			m.server.AddPeer(peerURL(host), id, m.token)
+			m.peers[id] = host
+		}
+	}
+	for id := range m.peers {
+		if _, ok := peers[id]; !ok {
+			m.server.RemovePeer(id)
+			delete(m.peers, id)
+			delete(m.clients, id)
+		}
+	}
+}
+
+// checkToken warns once when the secret changed since the peer token was
+// derived from it. Peers keep using the old token until they restart, since
+// remotedialer offers no way to change it while running.
+func (m *peerManager) checkToken() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.staleWarned || peerToken(m.secret.Get()) == m.token {
+		return
+	}
+	m.staleWarned = true
+	logrus.Warn("SECRET changed: peers keep authenticating with the previous secret until the proxy replicas are restarted")
+}
+
+func peerURL(host string) string {
+	return fmt.Sprintf("wss://%s/connect", host)
+}
+
+// IsLeader tells whether this replica has the lowest Pod name among itself and
+// its ready peers, which makes it the one writing state shared by all of them.
+func (m *peerManager) IsLeader() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id := range m.peers {
+		if id < m.server.PeerID {
+			return false
+		}
+	}
+	return true
+}
+
+// Clients returns the clients held by peers that can currently be dialed
+// through a peer session.
+func (m *peerManager) Clients() []string {
+	m.mu.Lock()
+	var clients []string
+	for _, peerClients := range m.clients {
+		for _, client := range peerClients {
+			if !slices.Contains(clients, client) {
+				clients = append(clients, client)
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	available := clients[:0]
+	for _, client := range clients {
+		if m.server.HasSession(client) {
+			available = append(available, client)
+		}
+	}
+	sort.Strings(available)
+	return available
+}
+
+// ServeHTTP lists the local clients to authenticated peers.
+func (m *peerManager) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	m.mu.Lock()
+	_, known := m.peers[req.Header.Get(remotedialer.ID)]
+	m.mu.Unlock()
+	token := req.Header.Get(remotedialer.Token)
+	if !known || subtle.ConstantTimeCompare([]byte(token), []byte(m.token)) != 1 {
+		http.Error(rw, "unknown peer", http.StatusUnauthorized)
+		return
+	}
+
+	clients := m.server.ListClients()
+	sort.Strings(clients)
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(clients); err != nil {
+		logrus.Debugf("writing peer clients failed: %v", err)
+	}
+}
+
+// watchClients polls the clients held by every peer until ctx is cancelled.
+func (m *peerManager) watchClients(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.checkToken()
+			m.syncClients(ctx)
+		}
+	}
+}
+
+func (m *peerManager) syncClients(ctx context.Context) {
+	m.mu.Lock()
+	peers := maps.Clone(m.peers)
+	m.mu.Unlock()
+
+	for id, host := range peers {
+		clients, err := m.fetchClients(ctx, id, host)
+		if err != nil {
+			logrus.WithField("peer", id).Debugf("listing peer clients failed: %v", err)
+			continue
+		}
+		m.mu.Lock()
+		if _, ok := m.peers[id]; ok {
+			m.clients[id] = clients
+		}
+		m.mu.Unlock()
+	}
+}
+
+func (m *peerManager) fetchClients(ctx context.Context, id, host string) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+host+peerClientsPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(remotedialer.ID, m.server.PeerID)
+	req.Header.Set(remotedialer.Token, m.token)
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("peer %s returned %s", id, resp.Status)
+	}
+
+	var clients []string
+	if err := json.NewDecoder(resp.Body).Decode(&clients); err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
@@ -0,0 +1,274 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/rancher/remotedialer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+type fakeEndpointSlices []*discoveryv1.EndpointSlice
+
+func (f fakeEndpointSlices) List(namespace string, selector labels.Selector) ([]*discoveryv1.EndpointSlice, error) {
+	var result []*discoveryv1.EndpointSlice
+	for _, slice := range f {
+		if slice.Namespace == namespace && selector.Matches(labels.Set(slice.Labels)) {
+			result = append(result, slice)
+		}
+	}
+	return result, nil
+}
+
+func TestPeerManagerEndpoints(t *testing.T) {
+	ready, notReady := true, false
+	endpoint := func(pod, ip string, ready *bool) discoveryv1.Endpoint {
+		return discoveryv1.Endpoint{
+			Addresses:  []string{ip},
+			Conditions: discoveryv1.EndpointConditions{Ready: ready},
+			TargetRef:  &corev1.ObjectReference{Kind: "Pod", Name: pod},
+		}
+	}
+	slices := fakeEndpointSlices{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "test-namespace",
+				Name:      "proxy-abc",
+				Labels:    map[string]string{discoveryv1.LabelServiceName: "proxy"},
+			},
+			Endpoints: []discoveryv1.Endpoint{
+				endpoint("proxy-0", "10.0.0.1", &ready),
+				endpoint("proxy-1", "10.0.0.2", &ready),
+				endpoint("proxy-2", "10.0.0.3", &notReady),
+				endpoint("proxy-3", "fd00::4", nil),
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "test-namespace",
+				Name:      "other-abc",
+				Labels:    map[string]string{discoveryv1.LabelServiceName: "other"},
+			},
+			Endpoints: []discoveryv1.Endpoint{endpoint("other-0", "10.0.1.1", &ready)},
+		},
+	}
+
+	m := newPeerManager(remotedialer.New(nil, remotedialer.DefaultErrorWriter), slices, fakeSecrets{}, &Config{
+		CertCANamespace: "test-namespace",
+		PodName:         "proxy-0",
+		PeerService:     "proxy",
+		HTTPSPort:       5555,
+	}, newSecretValue("SECRET", "secret", ""))
+
+	_, err := m.OnEndpointSliceChange("test-namespace/proxy-abc", slices[0])
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"proxy-1": "10.0.0.2:5555",
+		"proxy-3": "[fd00::4]:5555",
+	}, m.peers)
+
+	// Replicas going away are removed
+	m.clients["proxy-1"] = []string{"client-id"}
+	m.endpoints = fakeEndpointSlices{}
+	_, err = m.OnEndpointSliceChange("test-namespace/proxy-abc", nil)
+	require.NoError(t, err)
+	assert.Empty(t, m.peers)
+	assert.Empty(t, m.clients)
+}
+
+func TestPeerManagerIsLeader(t *testing.T) {
+	m := newPeerManager(remotedialer.New(nil, remotedialer.DefaultErrorWriter), fakeEndpointSlices{}, fakeSecrets{}, &Config{
+		CertCANamespace: "test-namespace",
+		PodName:         "proxy-1",
+		PeerService:     "proxy",
+	}, newSecretValue("SECRET", "secret", ""))
+	assert.True(t, m.IsLeader(), "a replica without peers leads")
+
+	m.peers["proxy-2"] = "10.0.0.3:5555"
+	assert.True(t, m.IsLeader())
+
+	m.peers["proxy-0"] = "10.0.0.1:5555"
+	assert.False(t, m.IsLeader(), "the lowest Pod name leads")
+}
+
+// startPeer starts a TLS remotedialer server with peering and a peer manager
+// serving its peer clients endpoint, like Start does. Every httptest server
+// shares a self-signed certificate for example.com, which peers trust as their
+// CA. It returns the server, its peer manager and its host:port.
+func startPeer(t *testing.T, id string, secret *secretValue) (*remotedialer.Server, *peerManager, string) {
+	t.Helper()
+
+	authorizer := func(req *http.Request) (string, bool, error) {
+		return "client-id", true, nil
+	}
+	server := remotedialer.New(authorizer, remotedialer.DefaultErrorWriter)
+	secrets := fakeSecrets{}
+	peers := newPeerManager(server, fakeEndpointSlices{}, secrets, &Config{
+		CertCANamespace: "test-namespace",
+		CAName:          "proxy-ca",
+		TLSName:         "example.com",
+		PodName:         id,
+		PeerService:     "proxy",
+	}, secret)
+
+	router := mux.NewRouter()
+	router.Handle("/connect", server)
+	router.Handle(peerClientsPath, peers)
+	ts := httptest.NewTLSServer(router)
+	t.Cleanup(ts.Close)
+	t.Cleanup(func() { peers.sync(map[string]string{}) })
+
+	secrets["test-namespace/proxy-ca"] = &corev1.Secret{
+		Data: map[string][]byte{
+			corev1.TLSCertKey: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}),
+		},
+	}
+	return server, peers, strings.TrimPrefix(ts.URL, "https://")
+}
+
+func TestPeerManagerDialsThroughPeer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, peersA, hostA := startPeer(t, "proxy-a", newSecretValue("SECRET", "secret", ""))
+	serverB, peersB, hostB := startPeer(t, "proxy-b", newSecretValue("SECRET", "secret", ""))
+	peersA.sync(map[string]string{"proxy-b": hostB})
+	peersB.sync(map[string]string{"proxy-a": hostA})
+
+	// The tunnel client only connects to proxy-b
+	echoPort := startEchoServer(t)
+	dialer := &websocket.Dialer{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	go connectTestClient(ctx, "wss://"+hostB+"/connect", http.Header{}, dialer)
+	require.Eventually(t, func() bool {
+		return len(serverB.ListClients()) > 0
+	}, time.Second, 10*time.Millisecond, "remotedialer client did not connect in time")
+
+	require.Eventually(t, func() bool {
+		peersA.syncClients(ctx)
+		return len(peersA.Clients()) > 0
+	}, 10*time.Second, 100*time.Millisecond, "proxy-a did not learn the clients of proxy-b")
+	assert.Equal(t, []string{"client-id"}, peersA.Clients())
+
+	// A connection accepted by proxy-a is dialed through proxy-b's client
+	l := newProxyListener(func() proxyRoute {
+		return proxyRoute{
+			PeerAddr:          fmt.Sprintf("127.0.0.1:%d", echoPort),
+			ClientSelection:   ClientSelectionRandom,
+			ClientWaitTimeout: time.Second,
+		}
+	}, peersA.server)
+	l.peers = peersA.Clients
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	require.NoError(t, l.bind(ctx, port))
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+}
+
+func TestPeerClientsRequiresPeerToken(t *testing.T) {
+	_, peers, host := startPeer(t, "proxy-a", newSecretValue("SECRET", "secret", ""))
+	peers.sync(map[string]string{"proxy-b": "127.0.0.1:1"})
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	for _, tt := range []struct {
+		name, id, token string
+		want            int
+	}{
+		{name: "known peer", id: "proxy-b", token: peerToken("secret"), want: http.StatusOK},
+		{name: "wrong token", id: "proxy-b", token: "other", want: http.StatusUnauthorized},
+		{name: "tunnel secret", id: "proxy-b", token: "secret", want: http.StatusUnauthorized},
+		{name: "unknown peer", id: "proxy-c", token: peerToken("secret"), want: http.StatusUnauthorized},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "https://"+host+peerClientsPath, nil)
+			require.NoError(t, err)
+			req.Header.Set(remotedialer.ID, tt.id)
+			req.Header.Set(remotedialer.Token, tt.token)
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.want, resp.StatusCode)
+		})
+	}
+}
+
+func TestPeerTokenFixedAtStart(t *testing.T) {
+	ctx := context.Background()
+	secretA := newSecretValue("SECRET", "secret", "")
+	secretB := newSecretValue("SECRET", "secret", "")
+	serverA, peersA, hostA := startPeer(t, "proxy-a", secretA)
+	_, peersB, hostB := startPeer(t, "proxy-b", secretB)
+	peersA.sync(map[string]string{"proxy-b": hostB})
+	peersB.sync(map[string]string{"proxy-a": hostA})
+
+	// Rotating the secret of either replica leaves peering as it was started
+	secretA.set("rotated")
+	secretB.set("rotated")
+	peersA.checkToken()
+	_, err := peersA.fetchClients(ctx, "proxy-b", hostB)
+	assert.NoError(t, err)
+	assert.Equal(t, peerToken("secret"), serverA.PeerToken)
+	assert.True(t, peersA.staleWarned)
+
+	// Replicas started with another secret do not peer
+	_, peersC, hostC := startPeer(t, "proxy-c", secretA)
+	peersC.sync(map[string]string{"proxy-b": hostB})
+	peersB.sync(map[string]string{"proxy-a": hostA, "proxy-c": hostC})
+	_, err = peersC.fetchClients(ctx, "proxy-b", hostB)
+	assert.ErrorContains(t, err, "401 Unauthorized")
+}
+
+func TestPeerClientsVerifiesCertificate(t *testing.T) {
+	ctx := context.Background()
+	_, peersA, hostA := startPeer(t, "proxy-a", newSecretValue("SECRET", "secret", ""))
+	_, peersB, hostB := startPeer(t, "proxy-b", newSecretValue("SECRET", "secret", ""))
+	peersB.sync(map[string]string{"proxy-a": hostA})
+
+	_, err := peersA.fetchClients(ctx, "proxy-b", hostB)
+	require.NoError(t, err)
+
+	// A certificate for another name is rejected
+	peersA.client.CloseIdleConnections()
+	peersA.serverName = "proxy.invalid"
+	_, err = peersA.fetchClients(ctx, "proxy-b", hostB)
+	assert.ErrorContains(t, err, "certificate is valid for example.com")
+
+	// So is one issued by another CA
+	peersA.serverName = "example.com"
+	peersA.client.CloseIdleConnections()
+	peersA.secrets.(fakeSecrets)["test-namespace/proxy-ca"] = testTLSSecret(t, "other-ca", "1")
+	_, err = peersA.fetchClients(ctx, "proxy-b", hostB)
+	assert.ErrorContains(t, err, "certificate signed by unknown authority")
+
+	// And nothing is sent without a CA to verify against
+	delete(peersA.secrets.(fakeSecrets), "test-namespace/proxy-ca")
+	_, err = peersA.fetchClients(ctx, "proxy-b", hostB)
+	assert.ErrorContains(t, err, "CA secret test-namespace/proxy-ca")
+}
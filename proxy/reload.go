@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const configReloadInterval = 10 * time.Second
+
+// configReloader watches Config.ConfigFile and applies changes to the running
+// proxy. Settings that are read per connection are swapped in place, listener
+// ports are rebound by apply, and settings that are only used at startup are
+// kept at their current value until the proxy restarts.
+type configReloader struct {
+	current *atomic.Pointer[Config]
+	apply   func(new *Config) error
+
+	lastData []byte
+}
+
+func (r *configReloader) watch(ctx context.Context, interval time.Duration) {
+	path := r.current.Load().ConfigFile
+	if path == "" {
+		return
+	}
+	r.lastData, _ = os.ReadFile(path)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			data, err := os.ReadFile(path)
+			if err != nil {
+				logrus.Errorf("proxy config reload failed: %v", err)
+				configReloads.WithLabelValues(reloadResultFailure).Inc()
+				continue
+			}
+			if bytes.Equal(data, r.lastData) {
+				continue
+			}
+			r.lastData = data
+			if err := r.reload(); err != nil {
+				logrus.Errorf("proxy config reload failed, keeping the previous configuration: %v", err)
+			}
+		}
+	}
+}
+
+func (r *configReloader) reload() error {
+	old := r.current.Load()
+
+	new, err := LoadConfig(old.ConfigFile)
+	if err != nil {
+		configReloads.WithLabelValues(reloadResultFailure).Inc()
+		return err
+	}
+	keepStartupSettings(old, new)
+
+	if err := r.apply(new); err != nil {
+		configReloads.WithLabelValues(reloadResultFailure).Inc()
+		return err
+	}
+	r.current.Store(new)
+
+	configReloads.WithLabelValues(reloadResultSuccess).Inc()
+	configLastReloadSuccess.SetToCurrentTime()
+	logrus.Infof("proxy config reloaded from %s: %s", new.ConfigFile, describeChanges(old, new))
+	return nil
+}
+
+type httpsBinder interface {
+	bind(ctx context.Context, cfg *Config) error
+}
+
+// rebind moves the proxy and HTTPS listeners to the ports of cfg. The new
+// proxy port is bound before the HTTPS listener moves, which only happens once
+// its own new port is bound, and served after. Either failing leaves both
+// listeners on their previous ports.
+func rebind(ctx context.Context, proxy *proxyListener, https httpsBinder, cfg *Config) error {
+	l, err := proxy.listen(cfg.ProxyPort)
+	if err != nil {
+		return fmt.Errorf("rebinding proxy listener: %w", err)
+	}
+	if err := https.bind(ctx, cfg); err != nil {
+		if l != nil {
+			l.Close()
+		}
+		return fmt.Errorf("rebinding https listener: %w", err)
+	}
+	if l != nil {
+		proxy.serveOn(ctx, l, cfg.ProxyPort)
+	}
+	return nil
+}
+
+// keepStartupSettings copies settings that cannot change without a restart
+// from old to new, warning about every one that was changed in the file.
+func keepStartupSettings(old, new *Config) {
+	keep := func(name string, oldValue, newValue any, restore func()) {
+		if oldValue != newValue {
+			logrus.Warnf("proxy config reload: %s changed from %v to %v, restart the proxy to apply it", name, oldValue, newValue)
+			restore()
+		}
+	}
+	keep("TLS_NAME", old.TLSName, new.TLSName, func() { new.TLSName = old.TLSName })
+	keep("CA_NAME", old.CAName, new.CAName, func() { new.CAName = old.CAName })
+	keep("CERT_CA_NAMESPACE", old.CertCANamespace, new.CertCANamespace, func() { new.CertCANamespace = old.CertCANamespace })
+	keep("CERT_CA_NAME", old.CertCAName, new.CertCAName, func() { new.CertCAName = old.CertCAName })
+	keep("SECRET_FILE", old.SecretFile, new.SecretFile, func() { new.SecretFile = old.SecretFile })
+	keep("ADMIN_TOKEN_FILE", old.AdminTokenFile, new.AdminTokenFile, func() { new.AdminTokenFile = old.AdminTokenFile })
+	keep("ADMIN_PORT", old.AdminPort, new.AdminPort, func() { new.AdminPort = old.AdminPort })
+	keep("POD_NAME", old.PodName, new.PodName, func() { new.PodName = old.PodName })
+	keep("POD_NAMESPACE", old.PodNamespace, new.PodNamespace, func() { new.PodNamespace = old.PodNamespace })
+	keep("EVENT_OBJECT", old.EventObject, new.EventObject, func() { new.EventObject = old.EventObject })
+	keep("NO_CLIENT_EVENT_THRESHOLD", old.NoClientEventThreshold, new.NoClientEventThreshold, func() { new.NoClientEventThreshold = old.NoClientEventThreshold })
+	keep("STATUS_CONFIGMAP", old.StatusConfigMap, new.StatusConfigMap, func() { new.StatusConfigMap = old.StatusConfigMap })
+	keep("APISERVICES", strings.Join(old.APIServices, ","), strings.Join(new.APIServices, ","), func() { new.APIServices = old.APIServices })
+	keep("APISERVICE_AVAILABILITY", old.APIServiceAvailability, new.APIServiceAvailability, func() { new.APIServiceAvailability = old.APIServiceAvailability })
+	keep("TUNNEL_ROUTES", old.TunnelRoutes, new.TunnelRoutes, func() { new.TunnelRoutes = old.TunnelRoutes })
+	keep("TLS_SANS", strings.Join(old.TLSSANs, ","), strings.Join(new.TLSSANs, ","), func() { new.TLSSANs = old.TLSSANs })
+	keep("TLS_IP_SANS", strings.Join(old.TLSIPSANs, ","), strings.Join(new.TLSIPSANs, ","), func() { new.TLSIPSANs = old.TLSIPSANs })
+	keep("TLS_MIN_VERSION", old.TLSMinVersion, new.TLSMinVersion, func() { new.TLSMinVersion = old.TLSMinVersion })
+	keep("TLS_CIPHER_SUITES", strings.Join(old.TLSCipherSuites, ","), strings.Join(new.TLSCipherSuites, ","), func() { new.TLSCipherSuites = old.TLSCipherSuites })
+	keep("TLS_SECRET", old.TLSSecret, new.TLSSecret, func() { new.TLSSecret = old.TLSSecret })
+	keep("CERT_EXPIRY_DAYS", old.CertExpiryDays, new.CertExpiryDays, func() { new.CertExpiryDays = old.CertExpiryDays })
+	keep("CERT_REGENERATE", old.CertRegenerate, new.CertRegenerate, func() { new.CertRegenerate = old.CertRegenerate })
+	keep("PEER_SERVICE", old.PeerService, new.PeerService, func() { new.PeerService = old.PeerService })
+	keep("TRACING_EXPORTER", old.TracingExporter, new.TracingExporter, func() { new.TracingExporter = old.TracingExporter })
+}
+
+func describeChanges(old, new *Config) string {
+	var changes []string
+	change := func(name string, oldValue, newValue any) {
+		if oldValue != newValue {
+			changes = append(changes, fmt.Sprintf("%s %v -> %v", name, oldValue, newValue))
+		}
+	}
+	change("PROXY_PORT", old.ProxyPort, new.ProxyPort)
+	change("HTTPS_PORT", old.HTTPSPort, new.HTTPSPort)
+	change("PEER_PORT", old.PeerPort, new.PeerPort)
+	change("CLIENT_SELECTION", old.ClientSelection, new.ClientSelection)
+	change("LOG_LEVEL", old.level(), new.level())
+	change("LOG_FORMAT", old.LogFormat, new.LogFormat)
+	if old.Secret != new.Secret {
+		changes = append(changes, "SECRET changed")
+	}
+	if old.AdminToken != new.AdminToken {
+		changes = append(changes, "ADMIN_TOKEN changed")
+	}
+
+	if len(changes) == 0 {
+		return "no changes"
+	}
+	return fmt.Sprint(changes)
+}
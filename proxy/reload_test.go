@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rancher/remotedialer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfigFile(t *testing.T, path string, peerPort int, extra string) {
+	t.Helper()
+	data := fmt.Sprintf(`TLS_NAME: test-tls
+CA_NAME: test-ca
+CERT_CA_NAMESPACE: test-namespace
+CERT_CA_NAME: test-cert-ca
+SECRET: test-secret
+PROXY_PORT: 8080
+PEER_PORT: %d
+HTTPS_PORT: 8443
+%s`, peerPort, extra)
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+}
+
+func TestConfigReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfigFile(t, path, 8081, "")
+
+	cfg, err := LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, 8081, cfg.PeerPort)
+	assert.Equal(t, ClientSelectionRandom, cfg.ClientSelection)
+
+	var current atomic.Pointer[Config]
+	current.Store(cfg)
+
+	var applied *Config
+	reloader := &configReloader{
+		current: &current,
+		apply: func(new *Config) error {
+			applied = new
+			return nil
+		},
+	}
+
+	// Live settings are applied, startup-only settings are kept
+	writeConfigFile(t, path, 9091, "CLIENT_SELECTION: round-robin\nLOG_LEVEL: debug\nCA_NAME: other-ca\n")
+	require.NoError(t, reloader.reload())
+	require.NotNil(t, applied)
+	assert.Equal(t, 9091, current.Load().PeerPort)
+	assert.Equal(t, ClientSelectionRoundRobin, current.Load().ClientSelection)
+	assert.Equal(t, "debug", current.Load().LogLevel)
+	assert.Equal(t, "test-ca", current.Load().CAName)
+
+	// An invalid file leaves the running configuration untouched
+	writeConfigFile(t, path, 70000, "")
+	require.Error(t, reloader.reload())
+	assert.Equal(t, 9091, current.Load().PeerPort)
+}
+
+func TestConfigFileValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfigFile(t, path, 8081, "TLS_SANS: [proxy.example.com, proxy.internal]\nTLS_IP_SANS:\n  - 10.0.0.1\nCERT_EXPIRY_DAYS: 1000000\n")
+
+	cfg, err := LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"proxy.example.com", "proxy.internal"}, cfg.TLSSANs)
+	assert.Equal(t, []string{"10.0.0.1"}, cfg.TLSIPSANs)
+	assert.Equal(t, 1000000, cfg.CertExpiryDays)
+
+	writeConfigFile(t, path, 8081, "TLS_SANS:\n  name: proxy.example.com\n")
+	_, err = LoadConfig(path)
+	assert.ErrorContains(t, err, "invalid TLS_SANS")
+
+	writeConfigFile(t, path, 8081, "TLS_SANS: [[proxy.example.com]]\n")
+	_, err = LoadConfig(path)
+	assert.ErrorContains(t, err, "invalid TLS_SANS")
+}
+
+func TestProxyListenerRebind(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	freePort := func() int {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer l.Close()
+		return l.Addr().(*net.TCPAddr).Port
+	}
+
+	cfg := &Config{}
+	l := newProxyListener(configRoute(func() *Config { return cfg }), remotedialer.New(nil, remotedialer.DefaultErrorWriter))
+
+	oldPort := freePort()
+	require.NoError(t, l.bind(ctx, oldPort))
+	oldConn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", oldPort))
+	require.NoError(t, err)
+	oldConn.Close()
+
+	newPort := freePort()
+	require.NoError(t, l.bind(ctx, newPort))
+	newConn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", newPort))
+	require.NoError(t, err)
+	newConn.Close()
+
+	assert.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", oldPort))
+		if err == nil {
+			conn.Close()
+		}
+		return err != nil
+	}, time.Second, 10*time.Millisecond, "old listener was not closed")
+}
+
+type fakeHTTPSBinder struct {
+	err   error
+	ports []int
+}
+
+func (f *fakeHTTPSBinder) bind(_ context.Context, cfg *Config) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.ports = append(f.ports, cfg.HTTPSPort)
+	return nil
+}
+
+func TestRebind(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	freePort := func() int {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer l.Close()
+		return l.Addr().(*net.TCPAddr).Port
+	}
+	accepts := func(port int) bool {
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}
+
+	cfg := &Config{}
+	l := newProxyListener(configRoute(func() *Config { return cfg }), remotedialer.New(nil, remotedialer.DefaultErrorWriter))
+	oldPort := freePort()
+	require.NoError(t, l.bind(ctx, oldPort))
+
+	// The HTTPS listener failing to move keeps the proxy listener in place
+	newPort := freePort()
+	https := &fakeHTTPSBinder{err: fmt.Errorf("address already in use")}
+	err := rebind(ctx, l, https, &Config{ProxyPort: newPort, HTTPSPort: 9443})
+	assert.ErrorContains(t, err, "rebinding https listener")
+	assert.Equal(t, oldPort, l.port)
+	assert.True(t, accepts(oldPort), "old listener should keep accepting")
+	assert.False(t, accepts(newPort), "new port should be released")
+
+	// So does the proxy port being taken, before the HTTPS listener moves
+	taken, err := net.Listen("tcp", "0.0.0.0:0")
+	require.NoError(t, err)
+	defer taken.Close()
+	https.err = nil
+	err = rebind(ctx, l, https, &Config{ProxyPort: taken.Addr().(*net.TCPAddr).Port, HTTPSPort: 9443})
+	assert.ErrorContains(t, err, "rebinding proxy listener")
+	assert.Empty(t, https.ports)
+	assert.Equal(t, oldPort, l.port)
+
+	// Both move once both bind
+	require.NoError(t, rebind(ctx, l, https, &Config{ProxyPort: newPort, HTTPSPort: 9443}))
+	assert.Equal(t, []int{9443}, https.ports)
+	assert.Equal(t, newPort, l.port)
+	assert.True(t, accepts(newPort))
+	assert.Eventually(t, func() bool { return !accepts(oldPort) }, time.Second, 10*time.Millisecond, "old listener was not closed")
+}
@@ -0,0 +1,207 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rancher/remotedialer"
+	"github.com/rancher/wrangler/v3/pkg/condition"
+	"github.com/sirupsen/logrus"
+
+	remotedialerv1 "github.com/rancher/remotedialer-proxy/pkg/apis/remotedialer.cattle.io/v1"
+)
+
+var (
+	routeReady           = condition.Cond(remotedialerv1.RouteReady)
+	routeClientAvailable = condition.Cond(remotedialerv1.RouteClientAvailable)
+)
+
+// routeController reconciles TunnelRoute resources into proxy listeners. Each
+// route gets its own proxyListener; spec changes other than the listen port
+// apply to new connections without rebinding.
+type routeController struct {
+	ctx      context.Context
+	server   *remotedialer.Server
+	peers    func() []string       // clients reachable through peer replicas, nil without peering
+	reserved func() map[int]string // ports used by the proxy itself, by config key
+	enqueue  func(namespace, name string)
+
+	mu        sync.Mutex
+	listeners map[string]*routeListener
+}
+
+type routeListener struct {
+	listener *proxyListener
+	port     int
+	route    atomic.Pointer[proxyRoute]
+}
+
+func newRouteController(ctx context.Context, server *remotedialer.Server, reserved func() map[int]string) *routeController {
+	return &routeController{
+		ctx:       ctx,
+		server:    server,
+		reserved:  reserved,
+		listeners: map[string]*routeListener{},
+	}
+}
+
+// configPorts returns the ports of the current configuration that routes must
+// not listen on.
+func configPorts(config func() *Config) func() map[int]string {
+	return func() map[int]string {
+		cfg := config()
+		ports := map[int]string{
+			cfg.ProxyPort: "PROXY_PORT",
+			cfg.HTTPSPort: "HTTPS_PORT",
+		}
+		if cfg.AdminPort > 0 {
+			ports[cfg.AdminPort] = "ADMIN_PORT"
+		}
+		return ports
+	}
+}
+
+// routeFromSpec converts a TunnelRoute spec, applying the same defaults as the
+// main proxy listener.
+func routeFromSpec(spec remotedialerv1.TunnelRouteSpec) (proxyRoute, error) {
+	if spec.ListenPort < 1 || spec.ListenPort > maxPort {
+		return proxyRoute{}, fmt.Errorf("listenPort %d out of range (1-%d)", spec.ListenPort, maxPort)
+	}
+	_, port, err := net.SplitHostPort(spec.Peer)
+	if err != nil {
+		return proxyRoute{}, fmt.Errorf("invalid peer %q: %w", spec.Peer, err)
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > maxPort {
+		return proxyRoute{}, fmt.Errorf("invalid peer %q: port must be between 1 and %d", spec.Peer, maxPort)
+	}
+
+	route := proxyRoute{
+		PeerAddr:          spec.Peer,
+		ClientSelection:   spec.ClientSelector.Policy,
+		ClientIDs:         spec.ClientSelector.ClientIDs,
+		ClientWaitTimeout: listClientsRetryCount * listClientSleepTime,
+	}
+	switch route.ClientSelection {
+	case "":
+		route.ClientSelection = ClientSelectionRandom
+	case ClientSelectionRandom, ClientSelectionRoundRobin:
+	default:
+		return proxyRoute{}, fmt.Errorf("invalid clientSelector.policy %q: must be %s or %s", route.ClientSelection, ClientSelectionRandom, ClientSelectionRoundRobin)
+	}
+	if spec.ClientWaitTimeout != nil {
+		route.ClientWaitTimeout = spec.ClientWaitTimeout.Duration
+	}
+	if spec.DialTimeout != nil {
+		route.DialTimeout = spec.DialTimeout.Duration
+	}
+	return route, nil
+}
+
+// OnChange is a TunnelRoute status handler. It starts, updates or rebinds the
+// route's listener; a returned error sets the Ready condition to False.
+func (c *routeController) OnChange(obj *remotedialerv1.TunnelRoute, status remotedialerv1.TunnelRouteStatus) (remotedialerv1.TunnelRouteStatus, error) {
+	if obj.DeletionTimestamp != nil {
+		return status, nil
+	}
+	key := obj.Namespace + "/" + obj.Name
+
+	route, err := routeFromSpec(obj.Spec)
+	if err != nil {
+		return status, err
+	}
+	if err := c.apply(key, int(obj.Spec.ListenPort), route); err != nil {
+		return status, err
+	}
+
+	status.ObservedGeneration = obj.Generation
+	available := len(route.matches(c.server.ListClients())) > 0
+	if !available && c.peers != nil {
+		available = len(route.matches(c.peers())) > 0
+	}
+	routeClientAvailable.SetStatusBool(&status, available)
+	if available {
+		routeClientAvailable.Message(&status, "")
+	} else {
+		routeClientAvailable.Message(&status, "no connected tunnel client matches the route")
+	}
+	return status, nil
+}
+
+// OnRemove stops the listener of a deleted TunnelRoute.
+func (c *routeController) OnRemove(key string, obj *remotedialerv1.TunnelRoute) (*remotedialerv1.TunnelRoute, error) {
+	if obj != nil && obj.DeletionTimestamp == nil {
+		return obj, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if l, ok := c.listeners[key]; ok {
+		logrus.WithField("route", key).Infof("stopping tunnel route listener on port %d", l.port)
+		l.listener.stop()
+		delete(c.listeners, key)
+	}
+	return obj, nil
+}
+
+// clientChanged requeues every route so its ClientAvailable condition follows
+// tunnel clients connecting and disconnecting.
+func (c *routeController) clientChanged(clientEvent) {
+	if c.enqueue == nil {
+		return
+	}
+
+	c.mu.Lock()
+	keys := make([]string, 0, len(c.listeners))
+	for key := range c.listeners {
+		keys = append(keys, key)
+	}
+	c.mu.Unlock()
+
+	for _, key := range keys {
+		namespace, name, _ := strings.Cut(key, "/")
+		c.enqueue(namespace, name)
+	}
+}
+
+func (c *routeController) apply(key string, port int, route proxyRoute) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if owner, ok := c.reserved()[port]; ok {
+		return fmt.Errorf("listenPort %d is already used by %s", port, owner)
+	}
+	for other, l := range c.listeners {
+		if other != key && l.port == port {
+			return fmt.Errorf("listenPort %d is already used by TunnelRoute %s", port, other)
+		}
+	}
+
+	l, ok := c.listeners[key]
+	if !ok {
+		l = &routeListener{}
+		l.listener = newProxyListener(func() proxyRoute { return *l.route.Load() }, c.server)
+		l.listener.peers = c.peers
+		// Nothing is served before the listener binds
+		l.route.Store(&route)
+	}
+	if ok && l.port == port {
+		l.route.Store(&route)
+		return nil
+	}
+
+	// A listener failing to move keeps serving the previous route
+	if err := l.listener.bind(c.ctx, port); err != nil {
+		return err
+	}
+	l.route.Store(&route)
+	logrus.WithField("route", key).Infof("tunnel route listening on port %d, relaying to %s", port, route.PeerAddr)
+	l.port = port
+	c.listeners[key] = l
+	return nil
+}
@@ -0,0 +1,190 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	remotedialerv1 "github.com/rancher/remotedialer-proxy/pkg/apis/remotedialer.cattle.io/v1"
+)
+
+func TestRouteFromSpec(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    remotedialerv1.TunnelRouteSpec
+		want    proxyRoute
+		wantErr string
+	}{
+		{
+			name: "defaults",
+			spec: remotedialerv1.TunnelRouteSpec{ListenPort: 7000, Peer: ":6666"},
+			want: proxyRoute{
+				PeerAddr:          ":6666",
+				ClientSelection:   ClientSelectionRandom,
+				ClientWaitTimeout: 10 * time.Second,
+			},
+		},
+		{
+			name: "all fields",
+			spec: remotedialerv1.TunnelRouteSpec{
+				ListenPort: 7000,
+				Peer:       "10.0.0.1:443",
+				ClientSelector: remotedialerv1.ClientSelector{
+					ClientIDs: []string{"a", "b"},
+					Policy:    ClientSelectionRoundRobin,
+				},
+				ClientWaitTimeout: &metav1.Duration{Duration: 2 * time.Second},
+				DialTimeout:       &metav1.Duration{Duration: 5 * time.Second},
+			},
+			want: proxyRoute{
+				PeerAddr:          "10.0.0.1:443",
+				ClientSelection:   ClientSelectionRoundRobin,
+				ClientIDs:         []string{"a", "b"},
+				ClientWaitTimeout: 2 * time.Second,
+				DialTimeout:       5 * time.Second,
+			},
+		},
+		{
+			name:    "listen port out of range",
+			spec:    remotedialerv1.TunnelRouteSpec{ListenPort: 70000, Peer: ":6666"},
+			wantErr: "listenPort 70000 out of range",
+		},
+		{
+			name:    "peer without port",
+			spec:    remotedialerv1.TunnelRouteSpec{ListenPort: 7000, Peer: "localhost"},
+			wantErr: `invalid peer "localhost"`,
+		},
+		{
+			name:    "peer port out of range",
+			spec:    remotedialerv1.TunnelRouteSpec{ListenPort: 7000, Peer: "localhost:0"},
+			wantErr: `invalid peer "localhost:0"`,
+		},
+		{
+			name: "invalid policy",
+			spec: remotedialerv1.TunnelRouteSpec{
+				ListenPort:     7000,
+				Peer:           ":6666",
+				ClientSelector: remotedialerv1.ClientSelector{Policy: "first"},
+			},
+			wantErr: `invalid clientSelector.policy "first"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := routeFromSpec(tt.spec)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRouteController(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	remoteDialerServer, peerPort := startTestTunnel(ctx, t)
+
+	freePort := func() int32 {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer l.Close()
+		return int32(l.Addr().(*net.TCPAddr).Port)
+	}
+
+	c := newRouteController(ctx, remoteDialerServer, func() map[int]string {
+		return map[int]string{8443: "HTTPS_PORT"}
+	})
+
+	route := &remotedialerv1.TunnelRoute{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "echo", Generation: 2},
+		Spec: remotedialerv1.TunnelRouteSpec{
+			ListenPort: freePort(),
+			Peer:       fmt.Sprintf("127.0.0.1:%d", peerPort),
+		},
+	}
+	status, err := c.OnChange(route, route.Status)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), status.ObservedGeneration)
+	assert.True(t, routeClientAvailable.IsTrue(&status))
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", route.Spec.ListenPort))
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+	conn.Close()
+
+	// Selecting a client that is not connected leaves the route without one
+	route.Spec.ClientSelector.ClientIDs = []string{"other-client"}
+	status, err = c.OnChange(route, status)
+	require.NoError(t, err)
+	assert.True(t, routeClientAvailable.IsFalse(&status))
+
+	// A matching client held by a peer replica is available too
+	c.peers = func() []string { return []string{"other-client"} }
+	status, err = c.OnChange(route, status)
+	require.NoError(t, err)
+	assert.True(t, routeClientAvailable.IsTrue(&status))
+	c.peers = nil
+
+	// A route failing to move to another port keeps its listener and spec
+	occupied, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer occupied.Close()
+	moved := route.DeepCopy()
+	moved.Spec.ListenPort = int32(occupied.Addr().(*net.TCPAddr).Port)
+	moved.Spec.ClientSelector.ClientIDs = []string{"client-id"}
+	_, err = c.OnChange(moved, status)
+	require.Error(t, err)
+	l := c.listeners["test-namespace/echo"]
+	assert.Equal(t, int(route.Spec.ListenPort), l.port)
+	assert.Equal(t, []string{"other-client"}, l.route.Load().ClientIDs)
+
+	// Ports of the proxy itself and of other routes are rejected
+	reserved := route.DeepCopy()
+	reserved.Name = "reserved"
+	reserved.Spec.ListenPort = 8443
+	_, err = c.OnChange(reserved, reserved.Status)
+	assert.ErrorContains(t, err, "listenPort 8443 is already used by HTTPS_PORT")
+
+	duplicate := route.DeepCopy()
+	duplicate.Name = "duplicate"
+	_, err = c.OnChange(duplicate, duplicate.Status)
+	assert.ErrorContains(t, err, "already used by TunnelRoute test-namespace/echo")
+
+	// Deleting the route closes its listener
+	_, err = c.OnRemove("test-namespace/echo", nil)
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", route.Spec.ListenPort))
+		if err == nil {
+			conn.Close()
+		}
+		return err != nil
+	}, time.Second, 10*time.Millisecond, "route listener was not closed")
+
+	// The port is free for another route once deleted
+	_, err = c.OnChange(duplicate, duplicate.Status)
+	require.NoError(t, err)
+
+	// Client changes requeue the routes to refresh ClientAvailable
+	var enqueued []string
+	c.enqueue = func(namespace, name string) { enqueued = append(enqueued, namespace+"/"+name) }
+	c.clientChanged(clientEvent{Type: clientConnected, ClientID: "client-id"})
+	assert.Equal(t, []string{"test-namespace/duplicate"}, enqueued)
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const secretFileReloadInterval = 10 * time.Second
+
+// secretValue holds a secret that is either fixed or read from a mounted file.
+// File-backed values are re-read periodically so that rotations done by the
+// kubelet are picked up without restarting the proxy.
+type secretValue struct {
+	name string
+	path string
+
+	mu    sync.RWMutex
+	value string
+}
+
+func newSecretValue(name, value, path string) *secretValue {
+	return &secretValue{
+		name:  name,
+		path:  path,
+		value: value,
+	}
+}
+
+func (s *secretValue) Get() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.value
+}
+
+func (s *secretValue) set(value string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := s.value != value
+	s.value = value
+	return changed
+}
+
+func (s *secretValue) reload() error {
+	value, err := readSecretFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	if s.set(value) {
+		logrus.Infof("proxy %s reloaded from %s", s.name, s.path)
+	}
+	return nil
+}
+
+// watch re-reads the file backing the secret every interval until ctx is done.
+// It is a no-op for secrets that were not loaded from a file.
+func (s *secretValue) watch(ctx context.Context, interval time.Duration) {
+	if s.path == "" {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.reload(); err != nil {
+				logrus.Errorf("proxy %s reload failed, keeping previous value: %v", s.name, err)
+			}
+		}
+	}
+}
+
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	value := strings.TrimRight(string(data), "\r\n")
+	if value == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return value, nil
+}
@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretValueReload(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(path, []byte("first\n"), 0o600))
+
+	value, err := readSecretFile(path)
+	require.NoError(t, err)
+
+	secret := newSecretValue("SECRET", value, path)
+	go secret.watch(ctx, 10*time.Millisecond)
+	assert.Equal(t, "first", secret.Get())
+
+	require.NoError(t, os.WriteFile(path, []byte("second\n"), 0o600))
+	assert.Eventually(t, func() bool {
+		return secret.Get() == "second"
+	}, 2*time.Second, 10*time.Millisecond, "secret was not reloaded")
+
+	// A broken rotation keeps serving the last good value
+	require.NoError(t, os.Remove(path))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, "second", secret.Get())
+}
@@ -2,155 +2,670 @@ package proxy
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"math/rand"
 	"net"
 	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
-	"github.com/rancher/dynamiclistener"
 	"github.com/rancher/dynamiclistener/server"
 
 	"github.com/rancher/wrangler/v3/pkg/generated/controllers/core"
+	v1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
+	"github.com/rancher/wrangler/v3/pkg/generated/controllers/discovery"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/rest"
 
 	"github.com/rancher/remotedialer"
+
+	remotedialercontroller "github.com/rancher/remotedialer-proxy/pkg/generated/controllers/remotedialer.cattle.io"
+	remotedialercontrollerv1 "github.com/rancher/remotedialer-proxy/pkg/generated/controllers/remotedialer.cattle.io/v1"
 )
 
 const (
 	listClientsRetryCount = 10
 	listClientSleepTime   = 1 * time.Second
+
+	clientIDHeader  = "X-API-Tunnel-Client-ID"
+	defaultClientID = "default"
 )
 
-func runProxyListener(ctx context.Context, cfg *Config, server *remotedialer.Server) error {
-	l, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", cfg.ProxyPort)) //this RDP app starts only once and always running
-	if err != nil {
+// clientID returns the remotedialer client key for a tunnel request. Clients
+// may name themselves through a header; the shared secret is never used as
+// the key since client keys end up in logs.
+func clientID(req *http.Request) string {
+	if id := req.Header.Get(clientIDHeader); id != "" {
+		return id
+	}
+	return defaultClientID
+}
+
+// tunnelAuthorizer accepts tunnel requests carrying the shared secret, keyed
+// by their clientID, and calls onFailure for the others.
+func tunnelAuthorizer(secret *secretValue, onFailure func(req *http.Request)) remotedialer.Authorizer {
+	return func(req *http.Request) (string, bool, error) {
+		if req.Header.Get("X-API-Tunnel-Secret") != secret.Get() {
+			if onFailure != nil {
+				onFailure(req)
+			}
+			return "", false, fmt.Errorf("X-API-Tunnel-Secret not specified in request header")
+		}
+		return clientID(req), true, nil
+	}
+}
+
+// clientSelector picks the remotedialer client a proxied connection is dialed
+// through, according to the configured ClientSelection policy.
+type clientSelector struct {
+	next atomic.Uint64
+}
+
+func (s *clientSelector) pick(policy string, clients []string) string {
+	if policy == ClientSelectionRoundRobin {
+		sort.Strings(clients)
+		return clients[(s.next.Add(1)-1)%uint64(len(clients))]
+	}
+	return clients[rand.Intn(len(clients))]
+}
+
+// proxyRoute describes where the connections accepted by a proxyListener are
+// relayed to.
+type proxyRoute struct {
+	PeerAddr          string        // address dialed on the client side of the tunnel
+	ClientSelection   string        // policy used to pick among matching clients
+	ClientIDs         []string      // clients the route may use, all when empty
+	ClientWaitTimeout time.Duration // how long to wait for a matching client
+	DialTimeout       time.Duration // bound on dialing PeerAddr, none when zero
+}
+
+// configRoute returns the route of the main proxy listener, which follows the
+// current configuration.
+func configRoute(config func() *Config) func() proxyRoute {
+	return func() proxyRoute {
+		cfg := config()
+		return proxyRoute{
+			PeerAddr:          fmt.Sprintf(":%d", cfg.PeerPort), // rancher's special https server for imperative API
+			ClientSelection:   cfg.ClientSelection,
+			ClientWaitTimeout: listClientsRetryCount * listClientSleepTime,
+		}
+	}
+}
+
+func (r proxyRoute) matches(clients []string) []string {
+	if len(r.ClientIDs) == 0 {
+		return clients
+	}
+	var matching []string
+	for _, client := range clients {
+		if slices.Contains(r.ClientIDs, client) {
+			matching = append(matching, client)
+		}
+	}
+	return matching
+}
+
+// proxyListener accepts kube-apiserver connections on the proxy port and relays
+// them through a remotedialer client. The port can be changed while running:
+// the new listener is bound first, then the old one stops accepting while the
+// connections it already accepted keep running until they close.
+type proxyListener struct {
+	route    func() proxyRoute
+	server   *remotedialer.Server
+	peers    func() []string // clients reachable through peer replicas, nil without peering
+	selector clientSelector
+	tracer   trace.Tracer
+	onError  func(error)
+
+	mu     sync.Mutex
+	port   int
+	cancel context.CancelFunc
+}
+
+func newProxyListener(route func() proxyRoute, server *remotedialer.Server) *proxyListener {
+	return &proxyListener{
+		route:  route,
+		server: server,
+		tracer: otel.Tracer(tracerName),
+	}
+}
+
+func (p *proxyListener) bind(ctx context.Context, port int) error {
+	l, err := p.listen(port)
+	if err != nil || l == nil {
 		return err
 	}
-	defer l.Close()
+	p.serveOn(ctx, l, port)
+	return nil
+}
+
+// listen binds port without accepting on it yet, so that the caller can still
+// back out by closing the listener. It returns a nil listener when port is
+// already served.
+func (p *proxyListener) listen(port int) (net.Listener, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cancel != nil && p.port == port {
+		return nil, nil
+	}
+	return net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", port)) //this RDP app starts only once and always running
+}
+
+// serveOn accepts on l, bound to port by listen, and stops accepting on the
+// previous listener.
+func (p *proxyListener) serveOn(ctx context.Context, l net.Listener, port int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	acceptCtx, cancel := context.WithCancel(ctx)
+	go p.serve(ctx, acceptCtx, l)
+
+	if p.cancel != nil {
+		logrus.Infof("proxy TCP listener moved from port %d to %d, draining the old listener", p.port, port)
+		p.cancel()
+	}
+	p.port = port
+	p.cancel = cancel
+}
+
+// stop closes the listener. Connections it accepted keep running.
+func (p *proxyListener) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cancel != nil {
+		p.cancel()
+		p.cancel = nil
+	}
+}
+
+func (p *proxyListener) serve(ctx, acceptCtx context.Context, l net.Listener) {
+	go func() {
+		<-acceptCtx.Done()
+		l.Close()
+	}()
 
 	for {
 		conn, err := l.Accept() // the client of 6666 is kube-apiserver, according to the APIService object spec, just to this TCP 6666
 		if err != nil {
+			if acceptCtx.Err() != nil {
+				return
+			}
 			logrus.Errorf("proxy TCP connection accept failed: %v", err)
 			continue
 		}
 
-		go func() {
-			var retryTimes = 0
-			for {
-				clients := server.ListClients()
-				if len(clients) == 0 {
-					retryTimes++
-					if retryTimes > listClientsRetryCount {
-						conn.Close()
-						return
-					}
-
-					logrus.Info("proxy TCP connection failed: no clients, retrying in a sec")
-					time.Sleep(listClientSleepTime)
-				} else {
-					client := clients[rand.Intn(len(clients))]
-					peerAddr := fmt.Sprintf(":%d", cfg.PeerPort) // rancher's special https server for imperative API
-					clientConn, err := server.Dialer(client)(ctx, "tcp", peerAddr)
-					if err != nil {
-						logrus.Errorf("proxy dialing %s failed: %v", peerAddr, err)
-						conn.Close()
-						return
-					}
-
-					go pipe(conn, clientConn)
-					go pipe(clientConn, conn)
-					break
-				}
-			}
-		}()
+		go p.handle(ctx, conn)
+	}
+}
+
+func (p *proxyListener) handle(ctx context.Context, conn net.Conn) {
+	connectionID := uuid.NewString()
+	log := logrus.WithFields(logrus.Fields{
+		"connection_id": connectionID,
+		"remote_addr":   conn.RemoteAddr().String(),
+	})
+	log.Debug("proxy TCP connection accepted")
+
+	ctx, span := p.tracer.Start(ctx, "proxy.accept", trace.WithAttributes(
+		attribute.String("proxy.connection_id", connectionID),
+		attribute.String("net.peer.addr", conn.RemoteAddr().String()),
+	))
+
+	route := p.route()
+	clients, err := p.waitForClients(ctx, log, route)
+	if err != nil {
+		log.Info("proxy TCP connection closed: no clients")
+		p.reportError(err)
+		endSpan(span, err)
+		conn.Close()
+		return
+	}
+
+	_, selectSpan := p.tracer.Start(ctx, "proxy.select_client", trace.WithAttributes(
+		attribute.String("proxy.client_selection", route.ClientSelection),
+		attribute.Int("proxy.clients", len(clients)),
+	))
+	client := p.selector.pick(route.ClientSelection, clients)
+	selectSpan.SetAttributes(attribute.String("proxy.client_id", client))
+	selectSpan.End()
+
+	log = log.WithField("client_id", client)
+	span.SetAttributes(attribute.String("proxy.client_id", client))
+
+	peerAddr := route.PeerAddr
+	dialCtx, dialSpan := p.tracer.Start(ctx, "proxy.tunnel_dial", trace.WithAttributes(
+		attribute.String("proxy.peer_addr", peerAddr),
+	))
+	if route.DialTimeout > 0 {
+		var cancel context.CancelFunc
+		dialCtx, cancel = context.WithTimeout(dialCtx, route.DialTimeout)
+		defer cancel()
+	}
+	clientConn, err := p.server.Dialer(client)(dialCtx, "tcp", peerAddr)
+	endSpan(dialSpan, err)
+	if err != nil {
+		log.Errorf("proxy dialing %s failed: %v", peerAddr, err)
+		p.reportError(fmt.Errorf("dialing %s through client %s: %w", peerAddr, client, err))
+		endSpan(span, err)
+		conn.Close()
+		return
+	}
+	log.Debugf("proxy dialed %s", peerAddr)
+
+	_, relaySpan := p.tracer.Start(ctx, "proxy.relay")
+	var wg sync.WaitGroup
+	var sent, received int64
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		received = pipe(log, conn, clientConn)
+	}()
+	go func() {
+		defer wg.Done()
+		sent = pipe(log, clientConn, conn)
+	}()
+	go func() {
+		wg.Wait()
+		relaySpan.SetAttributes(
+			attribute.Int64("proxy.bytes_to_client", received),
+			attribute.Int64("proxy.bytes_to_peer", sent),
+		)
+		relaySpan.End()
+		span.End()
+	}()
+}
+
+func (p *proxyListener) reportError(err error) {
+	if p.onError != nil {
+		p.onError(err)
+	}
+}
+
+// waitForClients returns the connected remotedialer clients matching the
+// route, falling back to clients held by peer replicas, and retrying for up to
+// the route's ClientWaitTimeout if none are connected yet.
+func (p *proxyListener) waitForClients(ctx context.Context, log *logrus.Entry, route proxyRoute) ([]string, error) {
+	_, span := p.tracer.Start(ctx, "proxy.wait_for_client")
+	defer span.End()
+
+	retryCount := int(route.ClientWaitTimeout / listClientSleepTime)
+	var retryTimes = 0
+	for {
+		clients := route.matches(p.server.ListClients())
+		if len(clients) == 0 && p.peers != nil {
+			clients = route.matches(p.peers())
+		}
+		if len(clients) > 0 {
+			span.SetAttributes(attribute.Int("proxy.retries", retryTimes))
+			return clients, nil
+		}
+
+		retryTimes++
+		if retryTimes > retryCount {
+			err := fmt.Errorf("no clients after %d retries", retryCount)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+
+		log.Info("proxy TCP connection failed: no clients, retrying in a sec")
+		time.Sleep(listClientSleepTime)
+	}
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 	}
+	span.End()
 }
 
-func pipe(a, b net.Conn) {
+func runProxyListener(ctx context.Context, cfg *Config, server *remotedialer.Server) error {
+	l := newProxyListener(configRoute(func() *Config { return cfg }), server)
+	if err := l.bind(ctx, cfg.ProxyPort); err != nil {
+		return err
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func pipe(log *logrus.Entry, a, b net.Conn) int64 {
 	defer func(a net.Conn) {
 		if err := a.Close(); err != nil {
-			logrus.Errorf("proxy TCP connection close failed: %v", err)
+			log.Errorf("proxy TCP connection close failed: %v", err)
 		}
 	}(a)
 	defer func(b net.Conn) {
 		if err := b.Close(); err != nil {
-			logrus.Errorf("proxy TCP connection close failed: %v", err)
+			log.Errorf("proxy TCP connection close failed: %v", err)
 		}
 	}(b)
 	n, err := io.Copy(a, b)
 	if err != nil {
-		logrus.Errorf("proxy copy failed: %v", err)
-		return
+		log.Errorf("proxy copy failed: %v", err)
+		return n
 	}
-	logrus.Debugf("proxy copied %d bytes to %v from %v", n, a.LocalAddr(), b.LocalAddr())
+	log.Debugf("proxy copied %d bytes to %v from %v", n, a.LocalAddr(), b.LocalAddr())
+	return n
 }
 
-func Start(cfg *Config, restConfig *rest.Config) error {
-	if cfg.Debug {
-		logrus.SetLevel(logrus.DebugLevel)
+// httpsListener serves the remotedialer /connect endpoint through
+// dynamiclistener. Like proxyListener it can move to a new port, in which case
+// the old server is shut down once the new one is listening. Tunnel sessions
+// are hijacked websocket connections and survive that shutdown.
+type httpsListener struct {
+	handler     http.Handler
+	secrets     v1.SecretController
+	secretCache v1.SecretCache // requested before the factory starts, which only starts the informers known by then
+
+	mu     sync.Mutex
+	port   int
+	cancel context.CancelFunc
+}
+
+func (h *httpsListener) bind(ctx context.Context, cfg *Config) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.cancel != nil && h.port == cfg.HTTPSPort {
+		return nil
+	}
+
+	serverCtx, cancel := context.WithCancel(ctx)
+	var err error
+	if cfg.TLSSecret != "" {
+		err = h.listenWithSecret(serverCtx, cfg)
+	} else {
+		err = h.listenWithDynamicListener(serverCtx, cfg)
+	}
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	if h.cancel != nil {
+		logrus.Infof("proxy HTTPS listener moved from port %d to %d, draining the old listener", h.port, cfg.HTTPSPort)
+		h.cancel()
+	}
+	h.port = cfg.HTTPSPort
+	h.cancel = cancel
+	return nil
+}
+
+// listenWithDynamicListener serves with a certificate generated and renewed by
+// dynamiclistener, signed by the CA in CAName.
+func (h *httpsListener) listenWithDynamicListener(ctx context.Context, cfg *Config) error {
+	listenerConfig, err := listenerConfig(cfg)
+	if err != nil {
+		return err
+	}
+	return server.ListenAndServe(ctx, cfg.HTTPSPort, 0, h.handler, &server.ListenOpts{
+		Secrets:           h.secrets,
+		CAName:            cfg.CAName,
+		CertName:          cfg.CertCAName,
+		CertNamespace:     cfg.CertCANamespace,
+		TLSListenerConfig: listenerConfig,
+	})
+}
+
+// listenWithSecret serves with the certificate of the externally managed
+// TLSSecret. Like dynamiclistener, cancelling ctx stops accepting while
+// established connections keep running.
+func (h *httpsListener) listenWithSecret(ctx context.Context, cfg *Config) error {
+	tlsConfig, err := tlsConfig(cfg)
+	if err != nil {
+		return err
+	}
+	tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+	tlsConfig.GetCertificate = (&secretCertificate{
+		secrets:   h.secretCache,
+		namespace: cfg.CertCANamespace,
+		name:      cfg.TLSSecret,
+	}).GetCertificate
+
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.HTTPSPort))
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Handler: h.handler,
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
 	}
+	go func() {
+		if err := srv.Serve(tls.NewListener(l, tlsConfig)); err != nil && err != http.ErrServerClosed {
+			logrus.Errorf("proxy HTTPS listener failed: %v", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		if err := srv.Shutdown(context.Background()); err != nil {
+			logrus.Errorf("proxy HTTPS listener shutdown failed: %v", err)
+		}
+	}()
+	return nil
+}
+
+func Start(cfg *Config, restConfig *rest.Config) error {
+	cfg.configureLogging()
 	ctx := context.Background()
 
+	shutdownTracing, err := setupTracing(ctx, cfg.TracingExporter)
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing(context.Background())
+
+	var current atomic.Pointer[Config]
+	current.Store(cfg)
+
+	secret := newSecretValue("SECRET", cfg.Secret, cfg.SecretFile)
+	go secret.watch(ctx, secretFileReloadInterval)
+	adminToken := newSecretValue("ADMIN_TOKEN", cfg.AdminToken, cfg.AdminTokenFile)
+	go adminToken.watch(ctx, secretFileReloadInterval)
+
+	events, err := newTunnelEventsFromConfig(ctx, cfg, restConfig)
+	if err != nil {
+		return err
+	}
+
+	var status *tunnelStatus
+
 	// Setting Up Default Authorizer
-	authorizer := func(req *http.Request) (string, bool, error) {
-		id := req.Header.Get("X-API-Tunnel-Secret")
-		if id != cfg.Secret {
-			return "", false, fmt.Errorf("X-API-Tunnel-Secret not specified in request header")
+	authorizer := tunnelAuthorizer(secret, func(req *http.Request) {
+		if events != nil {
+			events.authFailed(time.Now())
 		}
-		return id, true, nil
-	}
+		if status != nil {
+			status.setError(fmt.Errorf("tunnel authentication failed from %s", req.RemoteAddr))
+		}
+	})
 
 	// Initializing Remote Dialer Server
 	remoteDialerServer := remotedialer.New(authorizer, remotedialer.DefaultErrorWriter)
 
+	clients := newClientWatcher(remoteDialerServer)
+	clients.OnChange(func(event clientEvent) {
+		if event.Peer {
+			return
+		}
+		if event.Type == clientConnected {
+			logrus.WithField("client_id", event.ClientID).Info("tunnel client connected")
+		} else {
+			logrus.WithField("client_id", event.ClientID).Info("tunnel client disconnected")
+		}
+	})
+	if events != nil {
+		clients.OnChange(events.clientChanged)
+	}
+
 	router := mux.NewRouter()
 	router.Handle("/connect", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		logrus.Info("got a connection")
-		remoteDialerServer.ServeHTTP(w, req)
+		// Sessions must outlive the HTTPS server they were accepted on so that
+		// a listener rebind does not drop established tunnels.
+		remoteDialerServer.ServeHTTP(w, req.WithContext(context.WithoutCancel(req.Context())))
 	}))
 
-	go func() {
-		if err := runProxyListener(ctx, cfg, remoteDialerServer); err != nil {
-			logrus.Errorf("proxy listener failed to start in the background: %v", err)
-		}
-	}()
+	proxy := newProxyListener(configRoute(current.Load), remoteDialerServer)
 
-	// Setting Up Secret Controller
-	core, err := core.NewFactoryFromConfigWithOptions(restConfig, nil)
+	if cfg.AdminPort > 0 {
+		go func() {
+			if err := runAdminServer(ctx, cfg.AdminPort, adminToken.Get); err != nil {
+				logrus.Errorf("admin server failed: %v", err)
+			}
+		}()
+	}
+
+	// Setting Up Secret Controller. Every object the proxy reads lives in
+	// CertCANamespace, so the caches are kept to that namespace.
+	core, err := core.NewFactoryFromConfigWithOptions(restConfig, &core.FactoryOptions{
+		Namespace: cfg.CertCANamespace,
+	})
 	if err != nil {
 		return fmt.Errorf("build secret controller failed w/ err: %w", err)
 	}
 
+	secretController := core.Core().V1().Secret()
+
+	var peers *peerManager
+	if cfg.PeerService != "" {
+		discoveryFactory, err := discovery.NewFactoryFromConfigWithOptions(restConfig, &discovery.FactoryOptions{
+			Namespace: podNamespace(cfg),
+		})
+		if err != nil {
+			return fmt.Errorf("build endpointslice controller failed: %w", err)
+		}
+		endpointSlices := discoveryFactory.Discovery().V1().EndpointSlice()
+
+		peers = newPeerManager(remoteDialerServer, endpointSlices.Cache(), secretController.Cache(), cfg, secret)
+		endpointSlices.OnChange(ctx, "proxy-peers", peers.OnEndpointSliceChange)
+		router.Handle(peerClientsPath, peers)
+		proxy.peers = peers.Clients
+		clients.peers = peers.Clients
+		go peers.watchClients(ctx, peerSyncInterval)
+
+		if err := discoveryFactory.Start(ctx, 1); err != nil {
+			return fmt.Errorf("endpointslice controller factory start failed: %w", err)
+		}
+	}
+
+	// The watcher starts once it knows about peers, so that it never reports
+	// clients held by a peer as gone.
+	go clients.watch(ctx, clientWatchInterval)
+	if events != nil {
+		go events.watchNoClient(ctx, clients, clientWatchInterval)
+	}
+
+	if cfg.StatusConfigMap != "" {
+		status = newTunnelStatus(core.Core().V1().ConfigMap(), cfg.CertCANamespace, cfg.StatusConfigMap, clients.AllClients)
+		if peers != nil {
+			status.leader = peers.IsLeader
+		}
+		clients.OnChange(func(clientEvent) { status.markDirty() })
+		proxy.onError = status.setError
+		go status.run(ctx, statusUpdateInterval)
+	}
+
+	if err := proxy.bind(ctx, cfg.ProxyPort); err != nil {
+		logrus.Errorf("proxy listener failed to start in the background: %v", err)
+	}
+
+	if events != nil {
+		secretController.OnChange(ctx, "proxy-certificate-events", func(_ string, secret *corev1.Secret) (*corev1.Secret, error) {
+			if secret != nil && secret.Namespace == cfg.CertCANamespace && secret.Name == certificateSecret(cfg) {
+				events.certificateChanged(secret)
+			}
+			return secret, nil
+		})
+	}
+
+	if len(cfg.APIServices) > 0 {
+		dynamicClient, err := dynamic.NewForConfig(restConfig)
+		if err != nil {
+			return fmt.Errorf("build apiservice client failed: %w", err)
+		}
+		apiServices := newAPIServiceController(dynamicClient, cfg)
+		secretController.OnChange(ctx, "proxy-apiservice-cabundle", apiServices.OnCAChange)
+		if cfg.APIServiceAvailability {
+			go apiServices.watchAvailability(ctx, clients, apiServiceAvailabilityInterval)
+		}
+	}
+
+	if cfg.TunnelRoutes {
+		remotedialerFactory, err := remotedialercontroller.NewFactoryFromConfigWithOptions(restConfig, &remotedialercontroller.FactoryOptions{
+			Namespace: cfg.CertCANamespace,
+		})
+		if err != nil {
+			return fmt.Errorf("build tunnel route controller failed: %w", err)
+		}
+		tunnelRoutes := remotedialerFactory.Remotedialer().V1().TunnelRoute()
+
+		routes := newRouteController(ctx, remoteDialerServer, configPorts(current.Load))
+		if peers != nil {
+			routes.peers = peers.Clients
+		}
+		routes.enqueue = tunnelRoutes.Enqueue
+		clients.OnChange(routes.clientChanged)
+		remotedialercontrollerv1.RegisterTunnelRouteStatusHandler(ctx, tunnelRoutes, routeReady, "proxy-tunnel-route", routes.OnChange)
+		tunnelRoutes.OnChange(ctx, "proxy-tunnel-route-remove", routes.OnRemove)
+
+		if err := remotedialerFactory.Start(ctx, 1); err != nil {
+			return fmt.Errorf("tunnel route controller factory start failed: %w", err)
+		}
+	}
+
+	// Setting Up Remote Dialer HTTPS Server. The Secret cache is requested
+	// before the factory starts, as informers created later are never started.
+	https := &httpsListener{
+		handler:     router,
+		secrets:     secretController,
+		secretCache: secretController.Cache(),
+	}
+
 	if err := core.Start(ctx, 1); err != nil {
 		return fmt.Errorf("secretController factory start failed: %w", err)
 	}
 
-	secretController := core.Core().V1().Secret()
+	if err := https.bind(ctx, cfg); err != nil {
+		return fmt.Errorf("extension server exited with an error: %w", err)
+	}
 
-	// Setting Up Remote Dialer HTTPS Server
-	if err := server.ListenAndServe(ctx, cfg.HTTPSPort, 0, router, &server.ListenOpts{
-		Secrets:       secretController,
-		CAName:        cfg.CAName,
-		CertName:      cfg.CertCAName,
-		CertNamespace: cfg.CertCANamespace,
-		TLSListenerConfig: dynamiclistener.Config{
-			SANs: []string{cfg.TLSName},
-			FilterCN: func(cns ...string) []string {
-				return []string{cfg.TLSName}
-			},
-			RegenerateCerts: func() bool {
-				return true
-			},
-			ExpirationDaysCheck: 10,
+	reloader := &configReloader{
+		current: &current,
+		apply: func(new *Config) error {
+			if err := rebind(ctx, proxy, https, new); err != nil {
+				return err
+			}
+			new.configureLogging()
+			if new.SecretFile == "" {
+				secret.set(new.Secret)
+			}
+			if new.AdminTokenFile == "" {
+				adminToken.set(new.AdminToken)
+			}
+			return nil
 		},
-	}); err != nil {
-		return fmt.Errorf("extension server exited with an error: %w", err)
 	}
+	go reloader.watch(ctx, configReloadInterval)
+
 	<-ctx.Done()
 	return nil
 }
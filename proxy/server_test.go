@@ -13,6 +13,7 @@ import (
 
 	"github.com/gorilla/websocket"
 	"github.com/rancher/remotedialer"
+	"github.com/rancher/remotedialer-proxy/proxyclient"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -114,3 +115,47 @@ func TestRunProxyListener(t *testing.T) {
 
 	assert.Equal(t, message, string(buf), "expected to read '%s', but got '%s'", message, string(buf))
 }
+
+type noopForwarder struct{}
+
+func (noopForwarder) Start() error { return nil }
+func (noopForwarder) Stop()        {}
+
+func TestTunnelClientID(t *testing.T) {
+	server := remotedialer.New(tunnelAuthorizer(newSecretValue("SECRET", "test-secret", ""), nil), remotedialer.DefaultErrorWriter)
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/connect"
+
+	var clients []*proxyclient.ProxyClient
+	for _, opts := range [][]proxyclient.ProxyClientOpt{
+		{proxyclient.WithClientID("cluster-a")},
+		{proxyclient.WithClientID("cluster-b")},
+		nil,
+	} {
+		opts = append(opts, proxyclient.WithoutSecretWatcher(), proxyclient.WithForwardedPorts(6666), proxyclient.WithServerURL(wsURL))
+		c, err := proxyclient.New(context.Background(), "test-secret", "test-namespace", "", "", nil, noopForwarder{}, opts...)
+		require.NoError(t, err)
+		require.NoError(t, c.Start(context.Background()))
+		clients = append(clients, c)
+	}
+	defer func() {
+		for _, c := range clients {
+			c.Stop()
+			assert.NoError(t, c.Wait())
+		}
+	}()
+
+	require.Eventually(t, func() bool {
+		return len(server.ListClients()) == 3
+	}, 5*time.Second, 10*time.Millisecond, "clients did not connect")
+	assert.ElementsMatch(t, []string{"cluster-a", "cluster-b", defaultClientID}, server.ListClients())
+
+	// Round-robin now rotates through distinct clients
+	selector := &clientSelector{}
+	var picked []string
+	for range 3 {
+		picked = append(picked, selector.pick(ClientSelectionRoundRobin, server.ListClients()))
+	}
+	assert.ElementsMatch(t, []string{"cluster-a", "cluster-b", defaultClientID}, picked)
+}
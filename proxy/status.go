@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	statusUpdateInterval = 5 * time.Second
+
+	statusKeyClients       = "clients"
+	statusKeyLastError     = "lastError"
+	statusKeyLastErrorTime = "lastErrorTime"
+)
+
+// configMapClient is the subset of the wrangler ConfigMap client the status
+// publisher needs.
+type configMapClient interface {
+	Get(namespace, name string, opts metav1.GetOptions) (*corev1.ConfigMap, error)
+	Create(*corev1.ConfigMap) (*corev1.ConfigMap, error)
+	Update(*corev1.ConfigMap) (*corev1.ConfigMap, error)
+}
+
+// statusClient is a connected tunnel client as published in the status.
+type statusClient struct {
+	ID          string    `json:"id"`
+	ConnectedAt time.Time `json:"connectedAt"`
+}
+
+// tunnelStatus publishes the connected tunnel clients and the last tunnel
+// error into a ConfigMap, so other controllers can tell whether the tunnel is
+// up without reading logs. With peering, only the leader replica writes it.
+type tunnelStatus struct {
+	configMaps configMapClient
+	namespace  string
+	name       string
+	clients    func() map[string]time.Time
+	leader     func() bool // whether this replica writes the status, nil without peering
+
+	mu            sync.Mutex
+	lastError     string
+	lastErrorTime time.Time
+	dirty         bool
+}
+
+func newTunnelStatus(configMaps configMapClient, namespace, name string, clients func() map[string]time.Time) *tunnelStatus {
+	return &tunnelStatus{
+		configMaps: configMaps,
+		namespace:  namespace,
+		name:       name,
+		clients:    clients,
+		dirty:      true,
+	}
+}
+
+// setError records err as the last tunnel error.
+func (s *tunnelStatus) setError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastError = err.Error()
+	s.lastErrorTime = time.Now()
+	s.dirty = true
+}
+
+// markDirty schedules a status update, e.g. after clients changed.
+func (s *tunnelStatus) markDirty() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dirty = true
+}
+
+func (s *tunnelStatus) data() (map[string]string, error) {
+	clients := []statusClient{}
+	for id, connectedAt := range s.clients() {
+		clients = append(clients, statusClient{ID: id, ConnectedAt: connectedAt.UTC()})
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].ID < clients[j].ID
+	})
+	clientsJSON, err := json.Marshal(clients)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := map[string]string{
+		statusKeyClients: string(clientsJSON),
+	}
+	if s.lastError != "" {
+		data[statusKeyLastError] = s.lastError
+		data[statusKeyLastErrorTime] = s.lastErrorTime.UTC().Format(time.RFC3339)
+	}
+	return data, nil
+}
+
+func (s *tunnelStatus) update() error {
+	s.mu.Lock()
+	s.dirty = false
+	s.mu.Unlock()
+
+	data, err := s.data()
+	if err != nil {
+		return err
+	}
+
+	configMap, err := s.configMaps.Get(s.namespace, s.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = s.configMaps.Create(&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      s.name,
+				Namespace: s.namespace,
+			},
+			Data: data,
+		})
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	if maps.Equal(configMap.Data, data) {
+		return nil
+	}
+	configMap = configMap.DeepCopy()
+	configMap.Data = data
+	_, err = s.configMaps.Update(configMap)
+	return err
+}
+
+// flush writes the status if it changed since the last write. Replicas other
+// than the leader keep it pending, so that they write it once they lead.
+func (s *tunnelStatus) flush() {
+	s.mu.Lock()
+	dirty := s.dirty
+	s.mu.Unlock()
+
+	if !dirty || (s.leader != nil && !s.leader()) {
+		return
+	}
+	if err := s.update(); err != nil {
+		logrus.Errorf("updating tunnel status in configmap %s/%s failed: %v", s.namespace, s.name, err)
+		s.markDirty()
+	}
+}
+
+// run writes the status whenever it changed, at most once per interval.
+func (s *tunnelStatus) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.flush()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+type fakeConfigMaps struct {
+	configMap *corev1.ConfigMap
+	updates   int
+}
+
+func (f *fakeConfigMaps) Get(namespace, name string, _ metav1.GetOptions) (*corev1.ConfigMap, error) {
+	if f.configMap == nil {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, name)
+	}
+	return f.configMap.DeepCopy(), nil
+}
+
+func (f *fakeConfigMaps) Create(configMap *corev1.ConfigMap) (*corev1.ConfigMap, error) {
+	f.configMap = configMap.DeepCopy()
+	return configMap, nil
+}
+
+func (f *fakeConfigMaps) Update(configMap *corev1.ConfigMap) (*corev1.ConfigMap, error) {
+	f.configMap = configMap.DeepCopy()
+	f.updates++
+	return configMap, nil
+}
+
+func TestTunnelStatusUpdate(t *testing.T) {
+	connectedAt := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	clients := map[string]time.Time{}
+	configMaps := &fakeConfigMaps{}
+	status := newTunnelStatus(configMaps, "test-namespace", "tunnel-status", func() map[string]time.Time {
+		return clients
+	})
+
+	// Created on first update
+	require.NoError(t, status.update())
+	require.NotNil(t, configMaps.configMap)
+	assert.Equal(t, "test-namespace", configMaps.configMap.Namespace)
+	assert.Equal(t, "tunnel-status", configMaps.configMap.Name)
+	assert.Equal(t, "[]", configMaps.configMap.Data[statusKeyClients])
+
+	// Clients and errors are published
+	clients["b"] = connectedAt
+	clients["a"] = connectedAt
+	status.setError(errors.New("dial failed"))
+	require.NoError(t, status.update())
+	assert.Equal(t, 1, configMaps.updates)
+
+	var published []statusClient
+	require.NoError(t, json.Unmarshal([]byte(configMaps.configMap.Data[statusKeyClients]), &published))
+	assert.Equal(t, []statusClient{{ID: "a", ConnectedAt: connectedAt}, {ID: "b", ConnectedAt: connectedAt}}, published)
+	assert.Equal(t, "dial failed", configMaps.configMap.Data[statusKeyLastError])
+	assert.NotEmpty(t, configMaps.configMap.Data[statusKeyLastErrorTime])
+
+	// Unchanged status is not written again
+	require.NoError(t, status.update())
+	assert.Equal(t, 1, configMaps.updates)
+}
+
+func TestTunnelStatusLeader(t *testing.T) {
+	configMaps := &fakeConfigMaps{}
+	status := newTunnelStatus(configMaps, "test-namespace", "tunnel-status", func() map[string]time.Time {
+		return map[string]time.Time{}
+	})
+	leader := false
+	status.leader = func() bool { return leader }
+
+	// Other replicas keep the status pending
+	status.flush()
+	assert.Nil(t, configMaps.configMap)
+
+	leader = true
+	status.flush()
+	require.NotNil(t, configMaps.configMap)
+	assert.Equal(t, "[]", configMaps.configMap.Data[statusKeyClients])
+}
@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"sync"
+
+	"github.com/rancher/dynamiclistener"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	defaultCertExpiryDays = 10
+
+	tlsSecretCAKey = "ca.crt" // issuer of a TLSSecret, as written by cert-manager
+)
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsVersion parses a TLS_MIN_VERSION value. An empty version keeps the Go
+// default.
+func tlsVersion(version string) (uint16, error) {
+	if version == "" {
+		return 0, nil
+	}
+	if v, ok := tlsVersions[version]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version %q, must be 1.2 or 1.3", version)
+}
+
+// tlsCipherSuites maps cipher suite names, as listed by tls.CipherSuites, to
+// their IDs. Insecure suites are rejected.
+func tlsCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// tlsConfig returns the TLS settings of the https listener. Certificates are
+// filled in by dynamiclistener or by a secretCertificate.
+func tlsConfig(cfg *Config) (*tls.Config, error) {
+	minVersion, err := tlsVersion(cfg.TLSMinVersion)
+	if err != nil {
+		return nil, err
+	}
+	cipherSuites, err := tlsCipherSuites(cfg.TLSCipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:   minVersion,
+		CipherSuites: cipherSuites,
+	}, nil
+}
+
+// listenerConfig returns the dynamiclistener settings for the generated
+// certificate. Only the configured SANs are kept, CNs requested by clients
+// through SNI are discarded.
+func listenerConfig(cfg *Config) (dynamiclistener.Config, error) {
+	tlsConfig, err := tlsConfig(cfg)
+	if err != nil {
+		return dynamiclistener.Config{}, err
+	}
+
+	sans := append([]string{cfg.TLSName}, cfg.TLSSANs...)
+	sans = append(sans, cfg.TLSIPSANs...)
+	regenerate := cfg.CertRegenerate
+	expiryDays := cfg.CertExpiryDays
+	if expiryDays == 0 {
+		expiryDays = defaultCertExpiryDays
+	}
+	return dynamiclistener.Config{
+		TLSConfig: tlsConfig,
+		SANs:      sans,
+		FilterCN: func(cns ...string) []string {
+			return sans
+		},
+		RegenerateCerts: func() bool {
+			return regenerate
+		},
+		ExpirationDaysCheck: expiryDays,
+	}, nil
+}
+
+// certificateSecret returns the name of the secret holding the serving
+// certificate in CertCANamespace.
+func certificateSecret(cfg *Config) string {
+	if cfg.TLSSecret != "" {
+		return cfg.TLSSecret
+	}
+	return cfg.CertCAName
+}
+
+// caSecret returns the name of the secret in CertCANamespace holding the CA of
+// the serving certificate, and the key it is stored under.
+func caSecret(cfg *Config) (string, string) {
+	if cfg.TLSSecret != "" {
+		return cfg.TLSSecret, tlsSecretCAKey
+	}
+	return cfg.CAName, corev1.TLSCertKey
+}
+
+type secretGetter interface {
+	Get(namespace, name string) (*corev1.Secret, error)
+}
+
+// secretCertificate serves the certificate of an externally managed
+// kubernetes.io/tls secret, such as one issued by cert-manager. The secret is
+// read from the cache on every handshake, so a renewed certificate is served
+// as soon as the secret is updated.
+type secretCertificate struct {
+	secrets   secretGetter
+	namespace string
+	name      string
+
+	mu              sync.Mutex
+	resourceVersion string
+	certificate     *tls.Certificate
+}
+
+func (s *secretCertificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	secret, err := s.secrets.Get(s.namespace, s.name)
+	if err != nil {
+		return nil, fmt.Errorf("TLS secret %s/%s: %w", s.namespace, s.name, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.certificate != nil && s.resourceVersion == secret.ResourceVersion {
+		return s.certificate, nil
+	}
+	certificate, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return nil, fmt.Errorf("TLS secret %s/%s: %w", s.namespace, s.name, err)
+	}
+	s.certificate = &certificate
+	s.resourceVersion = secret.ResourceVersion
+	return s.certificate, nil
+}
@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestListenerConfig(t *testing.T) {
+	cfg := &Config{
+		TLSName:         "proxy.example.com",
+		TLSSANs:         []string{"proxy.local"},
+		TLSIPSANs:       []string{"10.0.0.1"},
+		TLSMinVersion:   "1.3",
+		TLSCipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+	}
+
+	lc, err := listenerConfig(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"proxy.example.com", "proxy.local", "10.0.0.1"}, lc.SANs)
+	assert.Equal(t, lc.SANs, lc.FilterCN("requested.example.com"))
+	assert.False(t, lc.RegenerateCerts())
+	assert.Equal(t, defaultCertExpiryDays, lc.ExpirationDaysCheck)
+	assert.Equal(t, uint16(tls.VersionTLS13), lc.TLSConfig.MinVersion)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}, lc.TLSConfig.CipherSuites)
+
+	cfg.CertRegenerate = true
+	cfg.CertExpiryDays = 30
+	lc, err = listenerConfig(cfg)
+	require.NoError(t, err)
+	assert.True(t, lc.RegenerateCerts())
+	assert.Equal(t, 30, lc.ExpirationDaysCheck)
+}
+
+type fakeSecrets map[string]*corev1.Secret
+
+func (f fakeSecrets) Get(namespace, name string) (*corev1.Secret, error) {
+	if secret, ok := f[namespace+"/"+name]; ok {
+		return secret, nil
+	}
+	return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "secrets"}, name)
+}
+
+func testTLSSecret(t *testing.T, commonName, resourceVersion string) *corev1.Secret {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "proxy-tls", ResourceVersion: resourceVersion},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			corev1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		},
+	}
+}
+
+func TestSecretCertificate(t *testing.T) {
+	secrets := fakeSecrets{}
+	certificate := &secretCertificate{secrets: secrets, namespace: "test-namespace", name: "proxy-tls"}
+
+	_, err := certificate.GetCertificate(nil)
+	assert.ErrorContains(t, err, "TLS secret test-namespace/proxy-tls")
+
+	secrets["test-namespace/proxy-tls"] = testTLSSecret(t, "first", "1")
+	first, err := certificate.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "first", commonName(t, first))
+
+	again, err := certificate.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Same(t, first, again, "certificate should only be parsed again when the secret changes")
+
+	// A renewed secret is served on the next handshake
+	secrets["test-namespace/proxy-tls"] = testTLSSecret(t, "renewed", "2")
+	renewed, err := certificate.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "renewed", commonName(t, renewed))
+}
+
+func commonName(t *testing.T, certificate *tls.Certificate) string {
+	t.Helper()
+	cert, err := x509.ParseCertificate(certificate.Certificate[0])
+	require.NoError(t, err)
+	return cert.Subject.CommonName
+}
@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
+)
+
+const (
+	TracingExporterNone   = "none"
+	TracingExporterOTLP   = "otlp"   // OTLP over HTTP, configured through the standard OTEL_EXPORTER_OTLP_* variables
+	TracingExporterStdout = "stdout" // pretty printed spans on stdout, for debugging
+
+	tracerName = "github.com/rancher/remotedialer-proxy/proxy"
+)
+
+// setupTracing installs a global tracer provider exporting to the configured
+// exporter. The returned function flushes and stops the provider.
+func setupTracing(ctx context.Context, exporter string) (func(context.Context) error, error) {
+	var spanExporter sdktrace.SpanExporter
+	var err error
+
+	switch exporter {
+	case "", TracingExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case TracingExporterOTLP:
+		spanExporter, err = otlptracehttp.New(ctx)
+	case TracingExporterStdout:
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating %s trace exporter: %w", exporter, err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName("remotedialer-proxy"))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return provider.Shutdown, nil
+}
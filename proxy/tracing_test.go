@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rancher/remotedialer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// startTestTunnel starts a remotedialer server with one connected client and
+// an echo server on the client side. It returns the server and the echo port.
+func startTestTunnel(ctx context.Context, t *testing.T) (*remotedialer.Server, int) {
+	t.Helper()
+
+	authorizer := func(req *http.Request) (string, bool, error) {
+		return "client-id", true, nil
+	}
+	remoteDialerServer := remotedialer.New(authorizer, remotedialer.DefaultErrorWriter)
+	wsServer := httptest.NewServer(remoteDialerServer)
+	t.Cleanup(wsServer.Close)
+
+	echoPort := startEchoServer(t)
+
+	wsURL := "ws" + strings.TrimPrefix(wsServer.URL, "http") + "/connect"
+	go connectTestClient(ctx, wsURL, http.Header{}, websocket.DefaultDialer)
+
+	require.Eventually(t, func() bool {
+		return len(remoteDialerServer.ListClients()) > 0
+	}, time.Second, 10*time.Millisecond, "remotedialer client did not connect in time")
+
+	return remoteDialerServer, echoPort
+}
+
+// connectTestClient connects a tunnel client allowing every dial and
+// disconnects it when ctx is cancelled. The session is ended by closing its
+// connection rather than by cancelling remotedialer.ConnectToProxy, which
+// returns without waiting for the session's pings to stop.
+func connectTestClient(ctx context.Context, wsURL string, headers http.Header, dialer *websocket.Dialer) error {
+	d := *dialer
+	d.NetDialContext = func(dialCtx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := (&net.Dialer{}).DialContext(dialCtx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		context.AfterFunc(ctx, func() { conn.Close() })
+		return conn, nil
+	}
+	return remotedialer.ConnectToProxy(context.WithoutCancel(ctx), wsURL, headers,
+		func(proto, address string) bool { return true }, &d, nil)
+}
+
+// startEchoServer starts a TCP server writing back everything it reads and
+// returns its port.
+func startEchoServer(t *testing.T) int {
+	t.Helper()
+
+	echoServer, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "failed to start echo server")
+	t.Cleanup(func() { echoServer.Close() })
+	go func() {
+		for {
+			conn, err := echoServer.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return echoServer.Addr().(*net.TCPAddr).Port
+}
+
+func TestProxyListenerTracing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	remoteDialerServer, peerPort := startTestTunnel(ctx, t)
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer provider.Shutdown(context.Background())
+
+	cfg := &Config{PeerPort: peerPort}
+	l := newProxyListener(configRoute(func() *Config { return cfg }), remoteDialerServer)
+	l.tracer = provider.Tracer(tracerName)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go l.serve(ctx, ctx, listener)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	conn.Close()
+
+	require.Eventually(t, func() bool {
+		return len(exporter.GetSpans()) == 5
+	}, 2*time.Second, 10*time.Millisecond, "expected all connection spans to end")
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+	root, ok := spans["proxy.accept"]
+	require.True(t, ok, "missing proxy.accept span")
+	for _, name := range []string{"proxy.wait_for_client", "proxy.select_client", "proxy.tunnel_dial", "proxy.relay"} {
+		span, ok := spans[name]
+		if assert.True(t, ok, "missing %s span", name) {
+			assert.Equal(t, root.SpanContext.SpanID(), span.Parent.SpanID(), "%s should be a child of proxy.accept", name)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package proxyclient
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"k8s.io/utils/clock"
+)
+
+const (
+	defaultBackoffInitialInterval = retryTimeout
+	defaultBackoffMaxInterval     = time.Minute
+	defaultBackoffMultiplier      = 2
+	defaultBackoffJitter          = 0.2
+	defaultBackoffResetAfter      = 30 * time.Second
+)
+
+// Backoff configures how long the client waits between failed attempts to
+// start the port-forward or connect to the proxy. Zero values use the
+// defaults.
+type Backoff struct {
+	InitialInterval time.Duration // wait after the first failure, defaults to 1s
+	MaxInterval     time.Duration // upper bound of the wait before jitter, defaults to 1m
+	Multiplier      float64       // growth of the wait per failure, defaults to 2
+	// Jitter spreads every wait randomly by up to this fraction in both
+	// directions, so that clusters losing the proxy at the same time do not
+	// reconnect in lockstep. Defaults to 0.2; negative values disable it.
+	Jitter float64
+	// ResetAfter is how long a session must stay up for the next failure to
+	// wait InitialInterval again. Defaults to 30s.
+	ResetAfter time.Duration
+}
+
+// WithBackoff sets the exponential backoff used between reconnect attempts.
+func WithBackoff(b Backoff) ProxyClientOpt {
+	return func(pc *ProxyClient) {
+		pc.backoff = b
+	}
+}
+
+func (b Backoff) withDefaults() Backoff {
+	if b.InitialInterval <= 0 {
+		b.InitialInterval = defaultBackoffInitialInterval
+	}
+	if b.MaxInterval <= 0 {
+		b.MaxInterval = defaultBackoffMaxInterval
+	}
+	if b.MaxInterval < b.InitialInterval {
+		b.MaxInterval = b.InitialInterval
+	}
+	if b.Multiplier < 1 {
+		b.Multiplier = defaultBackoffMultiplier
+	}
+	if b.Jitter == 0 {
+		b.Jitter = defaultBackoffJitter
+	}
+	b.Jitter = min(max(b.Jitter, 0), 1)
+	if b.ResetAfter <= 0 {
+		b.ResetAfter = defaultBackoffResetAfter
+	}
+	return b
+}
+
+// backoffTimer tracks consecutive failures of the connect loop.
+type backoffTimer struct {
+	Backoff
+	clock    clock.Clock
+	random   func() float64
+	failures int
+}
+
+func newBackoffTimer(b Backoff, clk clock.Clock) *backoffTimer {
+	return &backoffTimer{Backoff: b.withDefaults(), clock: clk, random: rand.Float64}
+}
+
+// next returns the wait after one more failure.
+func (b *backoffTimer) next() time.Duration {
+	interval := float64(b.InitialInterval) * math.Pow(b.Multiplier, float64(b.failures))
+	interval = min(interval, float64(b.MaxInterval))
+	b.failures++
+
+	interval *= 1 + b.Jitter*(2*b.random()-1)
+	return time.Duration(interval)
+}
+
+func (b *backoffTimer) reset() {
+	b.failures = 0
+}
+
+// sessionEnded resets the backoff when a session that connected at
+// connectedAt stayed up for at least ResetAfter.
+func (b *backoffTimer) sessionEnded(connectedAt time.Time) {
+	if !connectedAt.IsZero() && b.clock.Since(connectedAt) >= b.ResetAfter {
+		b.reset()
+	}
+}
+
+// wait blocks for the next backoff interval. It returns false if ctx is
+// cancelled first.
+func (b *backoffTimer) wait(ctx context.Context) bool {
+	return sleep(ctx, b.clock, b.next())
+}
+
+func sleep(ctx context.Context, clk clock.Clock, d time.Duration) bool {
+	timer := clk.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C():
+		return true
+	}
+}
@@ -0,0 +1,127 @@
+package proxyclient
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+func TestBackoffNext(t *testing.T) {
+	tests := []struct {
+		name    string
+		backoff Backoff
+		random  float64
+		want    []time.Duration
+	}{
+		{
+			name:   "defaults without jitter",
+			random: 0.5,
+			want:   []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 32 * time.Second, time.Minute, time.Minute},
+		},
+		{
+			name:    "custom multiplier and cap",
+			backoff: Backoff{InitialInterval: 100 * time.Millisecond, MaxInterval: time.Second, Multiplier: 3},
+			random:  0.5,
+			want:    []time.Duration{100 * time.Millisecond, 300 * time.Millisecond, 900 * time.Millisecond, time.Second},
+		},
+		{
+			name:    "lowest jitter",
+			backoff: Backoff{InitialInterval: time.Second, Jitter: 0.5},
+			random:  0,
+			want:    []time.Duration{500 * time.Millisecond, time.Second},
+		},
+		{
+			name:    "highest jitter",
+			backoff: Backoff{InitialInterval: time.Second, Jitter: 0.5},
+			random:  1,
+			want:    []time.Duration{1500 * time.Millisecond, 3 * time.Second},
+		},
+		{
+			name:    "jitter disabled",
+			backoff: Backoff{InitialInterval: time.Second, Jitter: -1},
+			random:  1,
+			want:    []time.Duration{time.Second, 2 * time.Second},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBackoffTimer(tt.backoff, testingclock.NewFakeClock(time.Now()))
+			b.random = func() float64 { return tt.random }
+
+			var got []time.Duration
+			for range tt.want {
+				got = append(got, b.next())
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestBackoffResetsAfterStableSession(t *testing.T) {
+	clock := testingclock.NewFakeClock(time.Now())
+	b := newBackoffTimer(Backoff{Jitter: -1, ResetAfter: time.Minute}, clock)
+	b.next()
+	b.next()
+
+	// A short session keeps backing off
+	connectedAt := clock.Now()
+	clock.Step(time.Minute - time.Second)
+	b.sessionEnded(connectedAt)
+	assert.Equal(t, 4*time.Second, b.next())
+
+	connectedAt = clock.Now()
+	clock.Step(time.Minute)
+	b.sessionEnded(connectedAt)
+	assert.Equal(t, time.Second, b.next())
+}
+
+type failingForwarder struct {
+	starts atomic.Int32
+}
+
+func (f *failingForwarder) Start() error {
+	f.starts.Add(1)
+	return errors.New("port-forward failed")
+}
+
+func (f *failingForwarder) Stop() {}
+
+func TestRunBacksOff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := testingclock.NewFakeClock(time.Now())
+	forwarder := &failingForwarder{}
+	c := &ProxyClient{
+		forwarder: forwarder,
+		dialer:    &websocket.Dialer{},
+		backoff:   Backoff{InitialInterval: time.Second, MaxInterval: 4 * time.Second, Jitter: -1},
+		clock:     clock,
+		tracer:    otel.Tracer(tracerName),
+	}
+	go c.run(ctx)
+
+	require.Eventually(t, func() bool {
+		return forwarder.starts.Load() == 1 && clock.HasWaiters()
+	}, time.Second, time.Millisecond)
+
+	for i, interval := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		starts := int32(i + 1)
+		clock.Step(interval - time.Millisecond)
+		assert.Never(t, func() bool { return forwarder.starts.Load() > starts }, 20*time.Millisecond, time.Millisecond,
+			"retried before %s", interval)
+
+		clock.Step(time.Millisecond)
+		require.Eventually(t, func() bool {
+			return forwarder.starts.Load() == starts+1 && clock.HasWaiters()
+		}, time.Second, time.Millisecond, "did not retry after %s", interval)
+	}
+}
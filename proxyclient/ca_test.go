@@ -0,0 +1,157 @@
+package proxyclient
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rancher/remotedialer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/clock"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+func testCASecret(t *testing.T, commonName string) *corev1.Secret {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "ca"},
+		Data: map[string][]byte{
+			corev1.TLSCertKey: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		},
+	}
+}
+
+func TestOnSecretChange(t *testing.T) {
+	var errs []error
+	c := &ProxyClient{
+		namespace:      "test-namespace",
+		certSecretName: "ca",
+		certServerName: "proxy.example.com",
+		clock:          clock.RealClock{},
+		onError:        func(err error) { errs = append(errs, err) },
+	}
+
+	dialer, caChanged := c.currentDialer()
+	assert.Nil(t, dialer)
+
+	secret := testCASecret(t, "first")
+	_, err := c.onSecretChange("test-namespace/ca", secret)
+	require.NoError(t, err)
+	dialer, _ = c.currentDialer()
+	require.NotNil(t, dialer)
+	assert.Equal(t, "proxy.example.com", dialer.TLSClientConfig.ServerName)
+	assert.NotNil(t, dialer.TLSClientConfig.RootCAs)
+
+	// Resyncs of the same CA do not notify sessions
+	_, err = c.onSecretChange("test-namespace/ca", secret.DeepCopy())
+	require.NoError(t, err)
+	assert.False(t, isClosed(caChanged))
+
+	// Other Secrets are ignored
+	_, err = c.onSecretChange("test-namespace/other", nil)
+	require.NoError(t, err)
+	dialer, _ = c.currentDialer()
+	assert.NotNil(t, dialer)
+
+	_, err = c.onSecretChange("test-namespace/ca", testCASecret(t, "second"))
+	require.NoError(t, err)
+	assert.True(t, isClosed(caChanged), "sessions should be notified of the new CA")
+
+	_, caChanged = c.currentDialer()
+	_, err = c.onSecretChange("test-namespace/ca", nil)
+	require.NoError(t, err)
+	dialer, _ = c.currentDialer()
+	assert.Nil(t, dialer, "dialer should be cleared with the Secret")
+	assert.True(t, isClosed(caChanged))
+
+	_, err = c.onSecretChange("test-namespace/ca", &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "ca"}})
+	assert.ErrorContains(t, err, "missing tls.crt")
+	assert.Len(t, errs, 1)
+}
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestReconnectOnCAChange(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server, serverURL := startTunnel(t)
+
+	clock := testingclock.NewFakeClock(time.Now())
+	var connects, disconnects atomic.Int32
+	c := newTestClient(t, serverURL,
+		WithReconnectOnCAChange(),
+		withClock(clock),
+		WithOnConnectCallback(func(context.Context, *remotedialer.Session) error {
+			connects.Add(1)
+			return nil
+		}),
+		WithOnDisconnect(func(err error) {
+			if err == nil {
+				disconnects.Add(1)
+			}
+		}))
+	c.setDialer(&websocket.Dialer{}, []byte("ca-1"))
+	go c.run(ctx)
+
+	require.Eventually(t, func() bool {
+		return connects.Load() == 1 && c.State().Phase == PhaseConnected
+	}, 5*time.Second, 10*time.Millisecond, "client did not connect")
+
+	c.setDialer(&websocket.Dialer{}, []byte("ca-1"))
+	assert.Never(t, func() bool { return connects.Load() > 1 }, 200*time.Millisecond, 10*time.Millisecond,
+		"reconnected although the CA did not change")
+
+	c.setDialer(&websocket.Dialer{}, []byte("ca-2"))
+	require.Eventually(t, func() bool {
+		return connects.Load() == 2 && c.State().Phase == PhaseConnected
+	}, 5*time.Second, 10*time.Millisecond, "client did not reconnect with the new CA")
+	assert.Equal(t, int32(1), disconnects.Load())
+
+	// Deleting the Secret closes the session and pauses connecting
+	c.clearDialer()
+	require.Eventually(t, func() bool {
+		return c.State().Phase == PhaseWaitingForCert && !server.HasSession("client-id")
+	}, 5*time.Second, 10*time.Millisecond, "client did not pause without a CA")
+
+	c.setDialer(&websocket.Dialer{}, []byte("ca-3"))
+	require.Eventually(t, clock.HasWaiters, time.Second, time.Millisecond)
+	clock.Step(getSecretRetryTimeout)
+	require.Eventually(t, func() bool {
+		return connects.Load() == 3 && c.State().Phase == PhaseConnected
+	}, 5*time.Second, 10*time.Millisecond, "client did not resume once the Secret was created again")
+	assert.NoError(t, c.State().LastError)
+}
@@ -1,12 +1,16 @@
 package proxyclient
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -14,7 +18,11 @@ import (
 	v1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
 
 	"github.com/sirupsen/logrus"
-	corev1 "k8s.io/api/core/v1"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/utils/clock"
 )
 
 const (
@@ -24,6 +32,14 @@ const (
 	retryTimeout             = 1 * time.Second
 	certificateWatchInterval = 10 * time.Second
 	getSecretRetryTimeout    = 5 * time.Second
+
+	tracerName = "github.com/rancher/remotedialer-proxy/proxyclient"
+)
+
+var (
+	errAlreadyStarted = errors.New("proxy client already started")
+	errNotStarted     = errors.New("proxy client not started")
+	errStopped        = errors.New("proxy client stopped")
 )
 
 type PortForwarder interface {
@@ -31,178 +47,536 @@ type PortForwarder interface {
 	Stop()
 }
 
+// ServerURLProvider is implemented by PortForwarders that reach the proxy at
+// an address of their own, such as forward.Direct. Unless WithServerURL or
+// WithServerEndpoints is set, the client connects to ServerURL, read after
+// every Start.
+type ServerURLProvider interface {
+	ServerURL() (string, error)
+}
+
 type ProxyClientOpt func(*ProxyClient)
 
 type ProxyClient struct {
 	forwarder           PortForwarder
 	serverUrl           string
+	forwarderServerURL  bool // connect to the ServerURL of forwarder
+	endpoints           []ServerEndpoint
+	replicas            ReplicaSource
 	serverConnectSecret string
+	clientID            string
 
 	dialer    *websocket.Dialer
 	dialerMtx sync.Mutex
+	caData    []byte        // PEM CA trusted by dialer
+	caChanged chan struct{} // closed when caData changes or is cleared
+
+	baseDialer           *websocket.Dialer
+	tlsConfig            *tls.Config
+	withoutSecretWatcher bool
+	reconnectOnCAChange  bool
+	proxyURL             string
+	proxy                func(*http.Request) (*url.URL, error)
+	pinnedPublicKeys     []string
+	pinSecretKey         string
+	pins                 []pin
+
+	caKey        string
+	caConfigMaps []configMapCASource
+	caFiles      []string
+	trustSources []string // sources that must be loaded to connect
+	trustMtx     sync.Mutex
+	trust        map[string]trustSource
 
 	secretController v1.SecretController
 	namespace        string
 	certSecretName   string
 	certServerName   string
 
-	onConnect func(ctx context.Context, session *remotedialer.Session) error
+	forwardedPorts      []int
+	allowedDestinations []string
+	allowDestination    func(proto, address string) bool
+	authorizeDial       func(proto, address string) bool
+
+	onConnect    func(ctx context.Context, session *remotedialer.Session) error
+	onDisconnect func(err error)
+	onError      func(err error)
+
+	stateMtx     sync.Mutex
+	state        State
+	stateVersion uint64
+	version      uint64
+	sessions     map[string]sessionState
+	subscribers  map[chan State]struct{}
+
+	leaderElection     *LeaderElection
+	onLeadershipChange func(leader bool)
+	leader             atomic.Bool
+
+	backoff Backoff
+	clock   clock.Clock
+
+	lifecycleMtx sync.Mutex
+	cancel       context.CancelFunc
+	stopWatching context.CancelFunc // stops the trust source watchers started by New
+	watchers     sync.WaitGroup     // CA file watchers
+	done         chan struct{}
+	stopped      bool
+	err          error
+
+	tracer trace.Tracer
 }
 
 func New(ctx context.Context, serverSharedSecret, namespace, certSecretName, certServerName string, secretController v1.SecretController, forwarder PortForwarder, opts ...ProxyClientOpt) (*ProxyClient, error) {
-	if secretController == nil {
-		return nil, fmt.Errorf("SecretController required")
-	}
-
-	if forwarder == nil {
-		return nil, fmt.Errorf("a PortForwarder must be provided")
-	}
-
 	if namespace == "" {
 		return nil, fmt.Errorf("namespace required")
 	}
 
-	if certSecretName == "" {
-		return nil, fmt.Errorf("certSecretName required")
-	}
-
 	if serverSharedSecret == "" {
 		return nil, fmt.Errorf("server shared secret must be provided")
 	}
 
-	serverUrl := fmt.Sprintf("%s:%d%s", defaultServerAddr, defaultServerPort, defaultServerPath)
-
 	client := &ProxyClient{
-		serverUrl:           serverUrl,
 		forwarder:           forwarder,
 		serverConnectSecret: serverSharedSecret,
 		certSecretName:      certSecretName,
 		certServerName:      certServerName,
 		namespace:           namespace,
+		clock:               clock.RealClock{},
+		tracer:              otel.Tracer(tracerName),
 	}
-
-	client.setUpBuildDialerCallback(ctx, certSecretName, secretController)
+	client.setState(PhaseWaitingForCert, nil)
 
 	for _, opt := range opts {
 		opt(client)
 	}
 
-	return client, nil
-}
+	if client.serverUrl == "" {
+		client.serverUrl = fmt.Sprintf("%s:%d%s", defaultServerAddr, defaultServerPort, defaultServerPath)
+		if _, ok := forwarder.(ServerURLProvider); ok && len(client.endpoints) == 0 && client.replicas == nil {
+			client.forwarderServerURL = true
+		}
+	}
 
-func (c *ProxyClient) setUpBuildDialerCallback(ctx context.Context, certSecretName string, secretController v1.SecretController) {
-	secretController.OnChange(ctx, certSecretName, func(_ string, newSecret *corev1.Secret) (*corev1.Secret, error) {
-		if newSecret == nil {
-			return nil, nil
+	if client.proxyURL != "" {
+		proxyURL, err := parseProxyURL(client.proxyURL)
+		if err != nil {
+			return nil, err
 		}
+		logrus.Infof("RDPClient: connecting through proxy %s", proxyURL.Redacted())
+		client.proxy = http.ProxyURL(proxyURL)
+	}
 
-		if newSecret.Name == c.certSecretName && newSecret.Namespace == c.namespace {
-			rootCAs, err := buildCertFromSecret(c.namespace, c.certSecretName, newSecret)
-			if err != nil {
-				logrus.Errorf("RDPClient: build certificate failed: %s", err.Error())
-				return nil, err
-			}
+	pins, err := parsePins(client.pinnedPublicKeys)
+	if err != nil {
+		return nil, err
+	}
+	client.pins = pins
 
-			c.dialerMtx.Lock()
-			c.dialer = &websocket.Dialer{
-				TLSClientConfig: &tls.Config{
-					RootCAs:    rootCAs,
-					ServerName: c.certServerName,
-				},
-			}
-			c.dialerMtx.Unlock()
-			logrus.Infof("RDPClient: certificate updated successfully")
+	watchSecret := !client.withoutSecretWatcher && (certSecretName != "" || !client.hasCASources())
+	if watchSecret {
+		if secretController == nil {
+			return nil, fmt.Errorf("SecretController required")
+		}
+		if certSecretName == "" {
+			return nil, fmt.Errorf("certSecretName required")
+		}
+	} else if client.pinSecretKey != "" {
+		return nil, fmt.Errorf("pinned public keys cannot be read from a Secret without the Secret watcher")
+	}
+
+	if forwarder == nil && client.replicas == nil {
+		return nil, fmt.Errorf("a PortForwarder must be provided")
+	}
+
+	if err := validateServerEndpoints(client.serverEndpoints()); err != nil {
+		return nil, err
+	}
+
+	authorizeDial, err := client.destinationAuthorizer()
+	if err != nil {
+		return nil, err
+	}
+	client.authorizeDial = authorizeDial
+
+	if client.leaderElection != nil {
+		if _, err := client.leaderElectionConfig(); err != nil {
+			return nil, err
 		}
+	}
+
+	if watchSecret || client.hasCASources() {
+		// The watchers registered here outlive New, so they are stopped by
+		// Stop or once the client stops running rather than with ctx.
+		watchCtx, stopWatching := context.WithCancel(ctx)
+		client.stopWatching = stopWatching
+		client.watchTrustSources(watchCtx, watchSecret, secretController)
+	} else {
+		client.dialer = client.buildDialer(nil, client.pins)
+	}
+
+	return client, nil
+}
+
+// setDialer swaps in a dialer trusting caData, and notifies sessions when it
+// replaces a dialer trusting another CA.
+func (c *ProxyClient) setDialer(dialer *websocket.Dialer, caData []byte) {
+	c.dialerMtx.Lock()
+	defer c.dialerMtx.Unlock()
+
+	changed := c.caData != nil && !bytes.Equal(c.caData, caData)
+	c.dialer = dialer
+	c.caData = caData
+	if changed {
+		c.notifyCAChanged()
+	}
+}
+
+// clearDialer drops the dialer while a source of the trust bundle is missing,
+// so that no new session is opened until it is available again.
+func (c *ProxyClient) clearDialer() {
+	c.dialerMtx.Lock()
+	defer c.dialerMtx.Unlock()
+
+	if c.dialer == nil {
+		return
+	}
+	c.dialer = nil
+	c.caData = nil
+	c.notifyCAChanged()
+}
+
+func (c *ProxyClient) notifyCAChanged() {
+	if c.caChanged != nil {
+		close(c.caChanged)
+		c.caChanged = nil
+	}
+}
 
-		return newSecret, nil
-	})
+// currentDialer returns the dialer, and a channel closed once the trusted CA
+// changes or the certificate Secret is deleted.
+func (c *ProxyClient) currentDialer() (*websocket.Dialer, <-chan struct{}) {
+	c.dialerMtx.Lock()
+	defer c.dialerMtx.Unlock()
+
+	if c.caChanged == nil {
+		c.caChanged = make(chan struct{})
+	}
+	return c.dialer, c.caChanged
 }
 
-func buildCertFromSecret(namespace, certSecretName string, secret *corev1.Secret) (*x509.CertPool, error) {
-	crtData, exists := secret.Data["tls.crt"]
-	if !exists {
-		return nil, fmt.Errorf("secret %s/%s missing tls.crt field", namespace, certSecretName)
+// buildDialer returns a copy of the base dialer whose TLS configuration, taken
+// from the TLS template or else the base dialer, trusts rootCAs and verifies
+// certServerName unless it sets a server name of its own, and requires one of
+// pins if any. Without a base dialer, the proxy comes from the environment.
+func (c *ProxyClient) buildDialer(rootCAs *x509.CertPool, pins []pin) *websocket.Dialer {
+	dialer := &websocket.Dialer{Proxy: http.ProxyFromEnvironment}
+	if c.baseDialer != nil {
+		d := *c.baseDialer
+		dialer = &d
+	}
+	if c.proxy != nil {
+		dialer.Proxy = c.proxy
 	}
 
-	rootCAs := x509.NewCertPool()
-	if ok := rootCAs.AppendCertsFromPEM(crtData); !ok {
-		return nil, fmt.Errorf("failed to parse tls.crt from secret into a CA pool")
+	var config *tls.Config
+	switch {
+	case c.tlsConfig != nil:
+		config = c.tlsConfig.Clone()
+	case dialer.TLSClientConfig != nil:
+		config = dialer.TLSClientConfig.Clone()
+	default:
+		config = &tls.Config{}
+	}
+	if rootCAs != nil {
+		config.RootCAs = rootCAs
+	}
+	if config.ServerName == "" {
+		config.ServerName = c.certServerName
+	}
+	if len(pins) > 0 {
+		config.VerifyConnection = verifyPins(pins, config.VerifyConnection)
 	}
+	dialer.TLSClientConfig = config
+	return dialer
+}
 
-	return rootCAs, nil
+// Run keeps a tunnel to the proxy open until ctx is cancelled or Stop is
+// called, and returns once everything it started has stopped. With leader
+// election enabled, only the leader holds the tunnel.
+func (c *ProxyClient) Run(ctx context.Context) error {
+	if err := c.Start(ctx); err != nil {
+		return err
+	}
+	return c.Wait()
 }
 
-func (c *ProxyClient) Run(ctx context.Context) {
+// Start runs the client in the background, like Run. A client can only be
+// started once.
+func (c *ProxyClient) Start(ctx context.Context) error {
+	c.lifecycleMtx.Lock()
+	defer c.lifecycleMtx.Unlock()
+	if c.done != nil {
+		return errAlreadyStarted
+	}
+	if c.stopped {
+		return errStopped
+	}
+
+	ctx, c.cancel = context.WithCancel(ctx)
+	done := make(chan struct{})
+	c.done = done
 	go func() {
-	LookForDialer:
-		for {
-			select {
-			case <-ctx.Done():
-				logrus.Infof("RDPClient: Received stop signal.")
-				return
-
-			default:
-				logrus.Info("RDPClient: Checking if dialer is built...")
-
-				c.dialerMtx.Lock()
-				dialer := c.dialer
-				c.dialerMtx.Unlock()
-
-				if dialer != nil {
-					logrus.Info("RDPClient: Dialer is built. Ready to start.")
-					break LookForDialer
-				}
+		defer close(done)
+		defer c.watchers.Wait()
+		defer c.stopTrustWatchers()
+		if c.leaderElection != nil {
+			c.err = c.runLeaderElection(ctx)
+			return
+		}
+		c.run(ctx)
+	}()
+	return nil
+}
+
+// Wait blocks until a started client has stopped, and returns the error it
+// stopped with, if any.
+func (c *ProxyClient) Wait() error {
+	c.lifecycleMtx.Lock()
+	done := c.done
+	c.lifecycleMtx.Unlock()
+	if done == nil {
+		return errNotStarted
+	}
+
+	<-done
+	return c.err
+}
+
+func (c *ProxyClient) run(ctx context.Context) {
+	if c.replicas != nil {
+		c.runReplicas(ctx)
+		return
+	}
+	c.connect(ctx, "", c.forwarder, c.serverEndpoints())
+}
 
-				logrus.Infof("RDPClient: Dialer is not built yet, waiting %d secs to re-check.", getSecretRetryTimeout/time.Second)
-				time.Sleep(getSecretRetryTimeout)
+// waitForDialer blocks until the dialer is built from the certificate Secret.
+// It returns false if ctx is cancelled first.
+func (c *ProxyClient) waitForDialer(ctx context.Context, name string) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			logrus.Infof("RDPClient: Received stop signal.")
+			return false
+
+		default:
+			logrus.Info("RDPClient: Checking if dialer is built...")
+			c.setSessionState(name, PhaseWaitingForCert, nil)
+
+			if dialer, _ := c.currentDialer(); dialer != nil {
+				logrus.Info("RDPClient: Dialer is built. Ready to start.")
+				return true
 			}
+
+			logrus.Infof("RDPClient: Dialer is not built yet, waiting %d secs to re-check.", getSecretRetryTimeout/time.Second)
+			sleep(ctx, c.clock, getSecretRetryTimeout)
+		}
+	}
+}
+
+// connect keeps a session named name open through forwarder to one of
+// endpoints until ctx is cancelled.
+func (c *ProxyClient) connect(ctx context.Context, name string, forwarder PortForwarder, endpoints []ServerEndpoint) {
+	forwarding := false
+	defer func() {
+		if forwarding {
+			forwarder.Stop()
 		}
+	}()
 
-		for {
-			select {
-			case <-ctx.Done():
-				logrus.Infof("RDPClient: Received signal to stop.")
-				return
+	backoff := newBackoffTimer(c.backoff, c.clock)
+	current := 0
+	attempt := 0
+	for {
+		select {
+		case <-ctx.Done():
+			logrus.Infof("RDPClient: Received signal to stop.")
+			return
+
+		default:
+			dialer, caChanged := c.currentDialer()
+			if dialer == nil {
+				if !c.waitForDialer(ctx, name) {
+					return
+				}
+				continue
+			}
 
-			default:
-				if err := c.forwarder.Start(); err != nil {
+			endpoint := endpoints[current]
+			attempt++
+			spanName := "proxyclient.connect"
+			if attempt > 1 {
+				spanName = "proxyclient.reconnect"
+			}
+			spanCtx, span := c.tracer.Start(ctx, spanName, trace.WithAttributes(
+				attribute.String("proxyclient.server_url", endpoint.URL),
+				attribute.Int("proxyclient.attempt", attempt),
+			))
+
+			c.setSessionState(name, PhasePortForwarding, c.sessionState(name).LastError)
+			_, forwardSpan := c.tracer.Start(spanCtx, "proxyclient.port_forward")
+			if err := forwarder.Start(); err != nil {
+				logrus.Errorf("RDPClient: %s ", err)
+				endSpan(forwardSpan, err)
+				endSpan(span, err)
+				c.fail(name, err)
+				backoff.wait(ctx)
+				continue
+			}
+			forwarding = true
+			if provider, ok := forwarder.(ServerURLProvider); ok && c.forwarderServerURL {
+				url, err := provider.ServerURL()
+				if err != nil {
 					logrus.Errorf("RDPClient: %s ", err)
-					time.Sleep(retryTimeout)
+					endSpan(forwardSpan, err)
+					endSpan(span, err)
+					forwarder.Stop()
+					forwarding = false
+					c.fail(name, err)
+					backoff.wait(ctx)
 					continue
 				}
+				endpoint.URL = url
+				span.SetAttributes(attribute.String("proxyclient.server_url", endpoint.URL))
+			}
+			forwardSpan.End()
 
-				logrus.Infof("RDPClient: connecting to %s", c.serverUrl)
+			logrus.Infof("RDPClient: connecting to %s", endpoint.URL)
 
-				headers := http.Header{}
-				headers.Set("X-API-Tunnel-Secret", c.serverConnectSecret)
+			headers := http.Header{}
+			headers.Set("X-API-Tunnel-Secret", c.serverConnectSecret)
+			if c.clientID != "" {
+				headers.Set("X-API-Tunnel-Client-ID", c.clientID)
+			}
 
-				onConnectAuth := func(proto, address string) bool { return true }
-				onConnect := func(sessionCtx context.Context, session *remotedialer.Session) error {
-					logrus.Infoln("RDPClient: remotedialer session connected!")
-					if c.onConnect != nil {
-						return c.onConnect(sessionCtx, session)
-					}
+			// The session is ended through tunnel, never by cancelling the
+			// context it runs with.
+			tunnel := newSessionConn()
+			stopOnCancel := context.AfterFunc(ctx, func() { tunnel.close(nil) })
+			var connectedAt atomic.Pointer[time.Time]
+			var failedBack, recycled atomic.Bool
+			onConnect := func(sessionCtx context.Context, session *remotedialer.Session) error {
+				if !c.setSessionStateFrom(name, PhaseConnecting, PhaseConnected, nil) {
 					return nil
 				}
+				logrus.Infoln("RDPClient: remotedialer session connected!")
+				now := c.clock.Now()
+				connectedAt.Store(&now)
+				span.AddEvent("session connected")
+				if current != 0 {
+					go c.watchPreferred(sessionCtx, dialer, endpoints[0], func() {
+						failedBack.Store(true)
+						tunnel.close(nil)
+					})
+				}
+				if c.reconnectOnCAChange {
+					go func() {
+						select {
+						case <-caChanged:
+							recycled.Store(true)
+							tunnel.close(nil)
+						case <-sessionCtx.Done():
+						}
+					}()
+				}
+				if c.onConnect != nil {
+					if err := c.onConnect(sessionCtx, session); err != nil {
+						tunnel.close(err)
+					}
+				}
+				return nil
+			}
 
-				c.dialerMtx.Lock()
-				dialer := c.dialer
-				c.dialerMtx.Unlock()
-
-				if err := remotedialer.ClientConnect(ctx, c.serverUrl, headers, dialer, onConnectAuth, onConnect); err != nil {
-					logrus.Errorf("RDPClient: remotedialer.ClientConnect error: %s", err.Error())
-					c.forwarder.Stop()
-					time.Sleep(retryTimeout)
+			c.setSessionState(name, PhaseConnecting, c.sessionState(name).LastError)
+			// ConnectToProxy rather than ClientConnect, which sleeps a fixed 5s
+			// after every failure on its own.
+			err := remotedialer.ConnectToProxy(context.WithoutCancel(spanCtx), endpoint.URL, headers, c.authorizeDial, tunnel.dialer(endpointDialer(dialer, endpoint)), onConnect)
+			stopOnCancel()
+			if closed, closeErr := tunnel.closedWith(); closed {
+				err = closeErr
+			}
+			endSpan(span, err)
+
+			if (failedBack.Load() || recycled.Load()) && ctx.Err() == nil {
+				if failedBack.Load() {
+					logrus.Infof("RDPClient: moving back from %s to preferred server %s", endpoint.URL, endpoints[0].URL)
+					current = 0
+				} else {
+					logrus.Infof("RDPClient: trusted CA changed, reconnecting to %s", endpoint.URL)
+				}
+				if c.onDisconnect != nil {
+					c.onDisconnect(nil)
+				}
+				forwarder.Stop()
+				forwarding = false
+				backoff.reset()
+				continue
+			}
+			if err != nil {
+				logrus.Errorf("RDPClient: remotedialer.ConnectToProxy error: %s", err.Error())
+				if at := connectedAt.Load(); at != nil {
+					backoff.sessionEnded(*at)
+					if c.onDisconnect != nil {
+						c.onDisconnect(err)
+					}
+				}
+				forwarder.Stop()
+				forwarding = false
+				c.fail(name, err)
+
+				// Fail over to the next endpoint right away, and only back off
+				// once every endpoint failed in turn
+				current = (current + 1) % len(endpoints)
+				if current == 0 {
+					backoff.wait(ctx)
 				}
 			}
 		}
-	}()
+	}
 }
 
+// Stop cancels the client, closing its session and port-forward and stopping
+// the watchers of its CA sources. It does not wait for them to close, which
+// Wait does. Stop can be called any number of times, concurrently and before
+// Start, after which Start fails.
 func (c *ProxyClient) Stop() {
-	if c.forwarder != nil {
-		c.forwarder.Stop()
-		logrus.Infoln("RDPClient: port-forward stopped.")
+	c.lifecycleMtx.Lock()
+	defer c.lifecycleMtx.Unlock()
+	if c.stopped {
+		return
+	}
+	c.stopped = true
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.stopTrustWatchers()
+	logrus.Infoln("RDPClient: stopping.")
+}
+
+func (c *ProxyClient) stopTrustWatchers() {
+	if c.stopWatching != nil {
+		c.stopWatching()
+	}
+}
+
+// WithClientID names the client to the proxy, which registers its session
+// under that ID rather than "default". Clients sharing a proxy need distinct
+// IDs for CLIENT_SELECTION and the client IDs of TunnelRoutes to tell them
+// apart.
+func WithClientID(id string) ProxyClientOpt {
+	return func(pc *ProxyClient) {
+		pc.clientID = id
 	}
 }
 
@@ -218,8 +592,57 @@ func WithOnConnectCallback(onConnect func(ctx context.Context, session *remotedi
 	}
 }
 
+// WithReconnectOnCAChange closes the session and reconnects right away when
+// the CA in the certificate Secret changes, so that the new CA is verified
+// without waiting for the session to fail. The session also closes when the
+// Secret is deleted.
+func WithReconnectOnCAChange() ProxyClientOpt {
+	return func(pc *ProxyClient) {
+		pc.reconnectOnCAChange = true
+	}
+}
+
+// WithCustomDialer sets the dialer the tunnel websocket is opened with, for
+// example to set a proxy or timeouts. The CA from the certificate Secret is
+// merged into a copy of its TLS configuration rather than replacing it. Its
+// Proxy is used as is, unless WithProxyURL is set.
 func WithCustomDialer(dialer *websocket.Dialer) ProxyClientOpt {
 	return func(pc *ProxyClient) {
-		pc.dialer = dialer
+		pc.baseDialer = dialer
+	}
+}
+
+// WithTLSConfig sets a TLS configuration template the CA from the certificate
+// Secret is merged into, instead of the one of WithCustomDialer.
+func WithTLSConfig(config *tls.Config) ProxyClientOpt {
+	return func(pc *ProxyClient) {
+		pc.tlsConfig = config
+	}
+}
+
+// WithoutSecretWatcher does not watch the certificate Secret, for callers
+// managing TLS themselves through WithCustomDialer or WithTLSConfig, which are
+// then used as is unless WithCAFromConfigMap or WithCAFromFile add a CA. New
+// accepts a nil SecretController and an empty certSecretName with this
+// option.
+func WithoutSecretWatcher() ProxyClientOpt {
+	return func(pc *ProxyClient) {
+		pc.withoutSecretWatcher = true
+	}
+}
+
+// WithTracerProvider sets the OpenTelemetry tracer provider used for session
+// connect and reconnect spans. The global provider is used by default.
+func WithTracerProvider(provider trace.TracerProvider) ProxyClientOpt {
+	return func(pc *ProxyClient) {
+		pc.tracer = provider.Tracer(tracerName)
+	}
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 	}
+	span.End()
 }
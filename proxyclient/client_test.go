@@ -0,0 +1,233 @@
+package proxyclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestBuildDialer(t *testing.T) {
+	rootCAs := x509.NewCertPool()
+	systemCAs := x509.NewCertPool()
+
+	tests := []struct {
+		name           string
+		client         *ProxyClient
+		wantServerName string
+		wantMinVersion uint16
+		wantTimeout    time.Duration
+		wantProxy      bool
+	}{
+		{
+			name:           "default",
+			client:         &ProxyClient{certServerName: "proxy.example.com"},
+			wantServerName: "proxy.example.com",
+			wantProxy:      true, // from the environment
+		},
+		{
+			name: "custom dialer",
+			client: &ProxyClient{
+				certServerName: "proxy.example.com",
+				baseDialer: &websocket.Dialer{
+					Proxy:            http.ProxyFromEnvironment,
+					HandshakeTimeout: 3 * time.Second,
+					TLSClientConfig:  &tls.Config{MinVersion: tls.VersionTLS13, RootCAs: systemCAs},
+				},
+			},
+			wantServerName: "proxy.example.com",
+			wantMinVersion: tls.VersionTLS13,
+			wantTimeout:    3 * time.Second,
+			wantProxy:      true,
+		},
+		{
+			name: "TLS template over the custom dialer",
+			client: &ProxyClient{
+				certServerName: "proxy.example.com",
+				baseDialer: &websocket.Dialer{
+					HandshakeTimeout: 3 * time.Second,
+					TLSClientConfig:  &tls.Config{MinVersion: tls.VersionTLS12},
+				},
+				tlsConfig: &tls.Config{MinVersion: tls.VersionTLS13, ServerName: "template.example.com"},
+			},
+			wantServerName: "template.example.com",
+			wantMinVersion: tls.VersionTLS13,
+			wantTimeout:    3 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialer := tt.client.buildDialer(rootCAs, nil)
+			assert.Same(t, rootCAs, dialer.TLSClientConfig.RootCAs)
+			assert.Equal(t, tt.wantServerName, dialer.TLSClientConfig.ServerName)
+			assert.Equal(t, tt.wantMinVersion, dialer.TLSClientConfig.MinVersion)
+			assert.Equal(t, tt.wantTimeout, dialer.HandshakeTimeout)
+			assert.Equal(t, tt.wantProxy, dialer.Proxy != nil)
+
+			if base := tt.client.baseDialer; base != nil {
+				assert.NotSame(t, base, dialer, "the custom dialer must not be modified")
+				assert.NotSame(t, rootCAs, base.TLSClientConfig.RootCAs)
+			}
+		})
+	}
+}
+
+func TestNewWithoutSecretWatcher(t *testing.T) {
+	_, err := New(context.Background(), "secret", "test-namespace", "", "", nil, &fakeForwarder{})
+	assert.ErrorContains(t, err, "SecretController required")
+
+	config := &tls.Config{ServerName: "proxy.example.com", MinVersion: tls.VersionTLS13}
+	c, err := New(context.Background(), "secret", "test-namespace", "", "", nil, &fakeForwarder{},
+		WithoutSecretWatcher(), WithForwardedPorts(6666), WithTLSConfig(config))
+	require.NoError(t, err)
+
+	dialer, _ := c.currentDialer()
+	require.NotNil(t, dialer, "the dialer should be ready without a Secret")
+	assert.Equal(t, config.ServerName, dialer.TLSClientConfig.ServerName)
+	assert.Equal(t, config.MinVersion, dialer.TLSClientConfig.MinVersion)
+	assert.Nil(t, dialer.TLSClientConfig.RootCAs, "system roots should be used")
+}
+
+// directForwarder reaches the proxy at url, as forward.Direct does.
+type directForwarder struct {
+	fakeForwarder
+	url string
+}
+
+func (f *directForwarder) ServerURL() (string, error) {
+	if f.url == "" {
+		return "", errors.New("no proxy address")
+	}
+	return f.url, nil
+}
+
+func TestServerURLFromForwarder(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := startTunnelServer(t, listener)
+	forwarder := &directForwarder{url: "ws://" + listener.Addr().String() + "/connect"}
+
+	c, err := New(context.Background(), "secret", "test-namespace", "", "", nil, forwarder,
+		WithoutSecretWatcher(), WithForwardedPorts(6666), WithServerURL("wss://proxy.example.com/connect"))
+	require.NoError(t, err)
+	assert.False(t, c.forwarderServerURL, "WithServerURL should take precedence")
+
+	c, err = New(context.Background(), "secret", "test-namespace", "", "", nil, forwarder, WithoutSecretWatcher(), WithForwardedPorts(6666))
+	require.NoError(t, err)
+	require.NoError(t, c.Start(context.Background()))
+	defer func() {
+		c.Stop()
+		assert.NoError(t, c.Wait())
+	}()
+
+	require.Eventually(t, func() bool {
+		return server.HasSession("client-id")
+	}, 5*time.Second, 10*time.Millisecond, "client did not connect to the forwarder's server URL")
+	assert.Positive(t, forwarder.started.Load())
+}
+
+func TestServerURLFromForwarderFails(t *testing.T) {
+	forwarder := &directForwarder{}
+	c, err := New(context.Background(), "secret", "test-namespace", "", "", nil, forwarder,
+		WithoutSecretWatcher(), WithForwardedPorts(6666), WithBackoff(Backoff{InitialInterval: time.Hour}))
+	require.NoError(t, err)
+	require.NoError(t, c.Start(context.Background()))
+
+	require.Eventually(t, func() bool {
+		return c.State().LastError != nil
+	}, 5*time.Second, 10*time.Millisecond, "connecting did not fail")
+	assert.ErrorContains(t, c.State().LastError, "no proxy address")
+	c.Stop()
+	assert.NoError(t, c.Wait())
+	assert.Equal(t, forwarder.started.Load(), forwarder.stopped.Load(), "port-forward left running")
+}
+
+func TestConnectSpans(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := startTunnelServer(t, listener)
+	serverURL := "ws://" + listener.Addr().String() + "/connect"
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedURL := "ws://" + closed.Addr().String() + "/connect"
+	closed.Close()
+
+	tests := []struct {
+		name      string
+		serverURL string
+		forwarder PortForwarder
+		wantEvent string
+		wantErr   string
+	}{
+		{name: "connect succeeds", serverURL: serverURL, forwarder: &fakeForwarder{}, wantEvent: "session connected"},
+		{name: "connect fails", serverURL: closedURL, forwarder: &fakeForwarder{}, wantErr: "connection refused"},
+		{name: "port-forward fails", serverURL: serverURL, forwarder: &failingForwarder{}, wantErr: "port-forward failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter := tracetest.NewInMemoryExporter()
+			provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+			defer provider.Shutdown(context.Background())
+
+			c, err := New(context.Background(), "secret", "test-namespace", "", "", nil, tt.forwarder,
+				WithoutSecretWatcher(), WithForwardedPorts(6666), WithServerURL(tt.serverURL), WithTracerProvider(provider),
+				WithBackoff(Backoff{InitialInterval: time.Hour}))
+			require.NoError(t, err)
+			require.NoError(t, c.Start(context.Background()))
+			if tt.wantErr != "" {
+				require.Eventually(t, func() bool {
+					return c.State().LastError != nil
+				}, 5*time.Second, 10*time.Millisecond, "connecting did not fail")
+			} else {
+				require.Eventually(t, func() bool {
+					return server.HasSession("client-id")
+				}, 5*time.Second, 10*time.Millisecond, "client did not connect")
+			}
+			c.Stop()
+			require.NoError(t, c.Wait())
+
+			spans := map[string]tracetest.SpanStub{}
+			for _, span := range exporter.GetSpans() {
+				spans[span.Name] = span
+			}
+			require.Len(t, spans, 2, "only the first attempt should have run")
+			connect, ok := spans["proxyclient.connect"]
+			require.True(t, ok, "missing proxyclient.connect span")
+			assert.Contains(t, connect.Attributes, attribute.String("proxyclient.server_url", tt.serverURL))
+			assert.Contains(t, connect.Attributes, attribute.Int("proxyclient.attempt", 1))
+
+			forward, ok := spans["proxyclient.port_forward"]
+			require.True(t, ok, "missing proxyclient.port_forward span")
+			assert.Equal(t, connect.SpanContext.SpanID(), forward.Parent.SpanID(), "port_forward should be a child of connect")
+			if _, failing := tt.forwarder.(*failingForwarder); failing {
+				assert.Equal(t, codes.Error, forward.Status.Code)
+			} else {
+				assert.Equal(t, codes.Unset, forward.Status.Code)
+			}
+
+			if tt.wantErr == "" {
+				assert.Equal(t, codes.Unset, connect.Status.Code)
+				require.NotEmpty(t, connect.Events)
+				assert.Equal(t, tt.wantEvent, connect.Events[0].Name)
+				return
+			}
+			assert.Equal(t, codes.Error, connect.Status.Code)
+			assert.Contains(t, connect.Status.Description, tt.wantErr)
+			require.NotEmpty(t, connect.Events)
+			assert.Equal(t, "exception", connect.Events[0].Name, "the error should be recorded")
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package proxyclient
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// WithAllowedDestinations limits the addresses the proxy may make this client
+// dial through the tunnel. Every entry is either an exact host:port, such as
+// "127.0.0.1:6666" or "[::1]:6666", or a CIDR with a port or port range, such
+// as "10.0.0.0/8:443" or "10.0.0.0/8:8000-8080". CIDRs only match IP
+// addresses, never hostnames.
+//
+// These are allowed next to the ports set with WithForwardedPorts.
+func WithAllowedDestinations(destinations ...string) ProxyClientOpt {
+	return func(pc *ProxyClient) {
+		pc.allowedDestinations = append(pc.allowedDestinations, destinations...)
+	}
+}
+
+// WithAllowedDestinationFunc allows the addresses for which allow returns
+// true, in addition to WithAllowedDestinations.
+func WithAllowedDestinationFunc(allow func(proto, address string) bool) ProxyClientOpt {
+	return func(pc *ProxyClient) {
+		pc.allowDestination = allow
+	}
+}
+
+// WithForwardedPorts allows the proxy to dial ports on a local address (an
+// empty host, localhost or a loopback IP), which is where it relays
+// connections to its PEER_PORT. New fails unless this option,
+// WithAllowedDestinations or WithAllowedDestinationFunc is set, since every
+// dial would be denied.
+func WithForwardedPorts(ports ...int) ProxyClientOpt {
+	return func(pc *ProxyClient) {
+		pc.forwardedPorts = append(pc.forwardedPorts, ports...)
+	}
+}
+
+type destination struct {
+	host     string // exact host, empty for CIDR entries
+	prefix   netip.Prefix
+	fromPort int
+	toPort   int
+}
+
+func parseDestination(entry string) (destination, error) {
+	if strings.Contains(entry, "/") {
+		i := strings.LastIndex(entry, ":")
+		if i < 0 {
+			return destination{}, fmt.Errorf("allowed destination %q: missing port", entry)
+		}
+		prefix, err := netip.ParsePrefix(entry[:i])
+		if err != nil {
+			return destination{}, fmt.Errorf("allowed destination %q: %w", entry, err)
+		}
+		fromPort, toPort, err := parsePortRange(entry[i+1:])
+		if err != nil {
+			return destination{}, fmt.Errorf("allowed destination %q: %w", entry, err)
+		}
+		return destination{prefix: prefix.Masked(), fromPort: fromPort, toPort: toPort}, nil
+	}
+
+	host, port, err := net.SplitHostPort(entry)
+	if err != nil {
+		return destination{}, fmt.Errorf("allowed destination %q: %w", entry, err)
+	}
+	if host == "" {
+		return destination{}, fmt.Errorf("allowed destination %q: missing host", entry)
+	}
+	p, err := parsePort(port)
+	if err != nil {
+		return destination{}, fmt.Errorf("allowed destination %q: %w", entry, err)
+	}
+	return destination{host: normalizeHost(host), fromPort: p, toPort: p}, nil
+}
+
+func parsePortRange(s string) (int, int, error) {
+	from, to, isRange := strings.Cut(s, "-")
+	fromPort, err := parsePort(from)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !isRange {
+		return fromPort, fromPort, nil
+	}
+	toPort, err := parsePort(to)
+	if err != nil {
+		return 0, 0, err
+	}
+	if toPort < fromPort {
+		return 0, 0, fmt.Errorf("invalid port range %q", s)
+	}
+	return fromPort, toPort, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return port, nil
+}
+
+// normalizeHost lowercases hostnames and unmaps IPv4-mapped IPv6 addresses,
+// so that equivalent spellings of a host match.
+func normalizeHost(host string) string {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return addr.Unmap().String()
+	}
+	return strings.ToLower(host)
+}
+
+func (d destination) allows(host string, port int) bool {
+	if port < d.fromPort || port > d.toPort {
+		return false
+	}
+	if d.host != "" {
+		return d.host == host
+	}
+	addr, err := netip.ParseAddr(host)
+	return err == nil && d.prefix.Contains(addr.Unmap())
+}
+
+func isLocalHost(host string) bool {
+	if host == "" || host == "localhost" {
+		return true
+	}
+	addr, err := netip.ParseAddr(host)
+	return err == nil && addr.Unmap().IsLoopback()
+}
+
+// destinationAuthorizer returns the remotedialer ConnectAuthorizer enforcing
+// the allowed destinations.
+func (c *ProxyClient) destinationAuthorizer() (func(proto, address string) bool, error) {
+	destinations := make([]destination, 0, len(c.allowedDestinations))
+	for _, entry := range c.allowedDestinations {
+		d, err := parseDestination(entry)
+		if err != nil {
+			return nil, err
+		}
+		destinations = append(destinations, d)
+	}
+	for _, port := range c.forwardedPorts {
+		if port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid forwarded port %d", port)
+		}
+	}
+	if len(c.forwardedPorts) == 0 && len(destinations) == 0 && c.allowDestination == nil {
+		return nil, errors.New("no forwarded ports or allowed destinations configured: every dial through the tunnel would be denied")
+	}
+
+	return func(proto, address string) bool {
+		if c.allowDestination != nil && c.allowDestination(proto, address) {
+			return true
+		}
+		if host, port, err := net.SplitHostPort(address); err == nil {
+			host = normalizeHost(host)
+			if p, err := parsePort(port); err == nil {
+				if isLocalHost(host) && slices.Contains(c.forwardedPorts, p) {
+					return true
+				}
+				for _, d := range destinations {
+					if d.allows(host, p) {
+						return true
+					}
+				}
+			}
+		}
+		logrus.Warnf("RDPClient: denied %s dial to %s, not an allowed destination", proto, address)
+		return false
+	}, nil
+}
@@ -0,0 +1,100 @@
+package proxyclient
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDestinationAuthorizer(t *testing.T) {
+	tests := []struct {
+		name    string
+		client  *ProxyClient
+		allowed []string
+		denied  []string
+	}{
+		{
+			name:    "forwarded ports on local addresses",
+			client:  &ProxyClient{forwardedPorts: []int{6666, 80}},
+			allowed: []string{":6666", "localhost:6666", "127.0.0.1:6666", "127.1.2.3:80", "[::1]:6666", "[::ffff:127.0.0.1]:6666"},
+			denied:  []string{"127.0.0.1:6667", ":22", "localhost:2379", "10.0.0.1:6666", "example.com:443", "[fd00::1]:6666", "6666"},
+		},
+		{
+			name:    "forwarded ports next to allowed destinations",
+			client:  &ProxyClient{forwardedPorts: []int{6666}, allowedDestinations: []string{"10.0.0.1:443"}},
+			allowed: []string{"127.0.0.1:6666", "10.0.0.1:443"},
+			denied:  []string{"127.0.0.1:443", "10.0.0.1:6666"},
+		},
+		{
+			name:    "exact entries",
+			client:  &ProxyClient{allowedDestinations: []string{"Metrics.Local:9090", "10.0.0.1:443", "[fd00::1]:443"}},
+			allowed: []string{"metrics.local:9090", "10.0.0.1:443", "[::ffff:10.0.0.1]:443", "[fd00::1]:443"},
+			denied:  []string{":6666", "127.0.0.1:6666", "metrics.local:9091", "10.0.0.2:443"},
+		},
+		{
+			name:    "CIDR entries",
+			client:  &ProxyClient{allowedDestinations: []string{"10.0.0.0/8:443", "192.168.1.7/24:8000-8080", "fd00::/8:22"}},
+			allowed: []string{"10.1.2.3:443", "192.168.1.200:8000", "192.168.1.1:8080", "[fd12::1]:22"},
+			denied:  []string{"11.0.0.1:443", "10.1.2.3:444", "192.168.1.1:8081", "192.168.2.1:8000", "localhost:443"},
+		},
+		{
+			name: "custom func",
+			client: &ProxyClient{
+				allowedDestinations: []string{"10.0.0.1:443"},
+				allowDestination: func(proto, address string) bool {
+					return proto == "tcp" && address == "api.internal:443"
+				},
+			},
+			allowed: []string{"api.internal:443", "10.0.0.1:443"},
+			denied:  []string{"api.internal:80", ":6666"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authorize, err := tt.client.destinationAuthorizer()
+			require.NoError(t, err)
+			for _, address := range tt.allowed {
+				assert.True(t, authorize("tcp", address), "%s should be allowed", address)
+			}
+			for _, address := range tt.denied {
+				assert.False(t, authorize("tcp", address), "%s should be denied", address)
+			}
+		})
+	}
+}
+
+func TestInvalidForwardedPorts(t *testing.T) {
+	for _, port := range []int{0, -1, 65536} {
+		_, err := (&ProxyClient{forwardedPorts: []int{port}}).destinationAuthorizer()
+		assert.ErrorContains(t, err, "invalid forwarded port")
+	}
+}
+
+func TestInvalidAllowedDestinations(t *testing.T) {
+	for _, entry := range []string{
+		"10.0.0.1",
+		":443",
+		"10.0.0.1:0",
+		"10.0.0.1:65536",
+		"10.0.0.0/8",
+		"10.0.0.0/33:443",
+		"10.0.0.0/8:9000-8000",
+		"10.0.0.0/8:a-b",
+	} {
+		t.Run(entry, func(t *testing.T) {
+			_, err := (&ProxyClient{allowedDestinations: []string{entry}}).destinationAuthorizer()
+			assert.ErrorContains(t, err, entry)
+		})
+	}
+}
+
+func TestNoAllowedDestinations(t *testing.T) {
+	_, err := New(context.Background(), "secret", "test-namespace", "", "", nil, &fakeForwarder{}, WithoutSecretWatcher())
+	assert.ErrorContains(t, err, "no forwarded ports or allowed destinations configured")
+
+	_, err = New(context.Background(), "secret", "test-namespace", "", "", nil, &fakeForwarder{}, WithoutSecretWatcher(),
+		WithAllowedDestinationFunc(func(proto, address string) bool { return false }))
+	assert.NoError(t, err, "a custom func is enough")
+}
@@ -0,0 +1,156 @@
+package proxyclient
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	preferredServerCheckInterval = 30 * time.Second
+	preferredServerCheckTimeout  = 5 * time.Second
+)
+
+// ServerEndpoint is a proxy the client can connect to.
+type ServerEndpoint struct {
+	URL string // ws:// or wss:// URL of the proxy connect path
+	// ServerName is the TLS server name verified for this endpoint. Defaults
+	// to the certServerName passed to New.
+	ServerName string
+}
+
+// ServerOrder is the order in which server endpoints are tried.
+type ServerOrder int
+
+const (
+	// ServerOrderPreferred tries endpoints in the configured order, the first
+	// one being preferred.
+	ServerOrderPreferred ServerOrder = iota
+	// ServerOrderRandom shuffles the endpoints once when the client is
+	// created, so that clients spread over the proxies.
+	ServerOrderRandom
+)
+
+// WithServerEndpoints sets the proxies to connect to, instead of WithServerURL.
+// When an endpoint cannot be reached, or its session ends, the client fails
+// over to the next one, backing off after every endpoint failed in turn.
+// While connected to another endpoint than the preferred one, the preferred
+// one is checked every 30 seconds and the client moves back to it once it is
+// reachable again.
+func WithServerEndpoints(order ServerOrder, endpoints ...ServerEndpoint) ProxyClientOpt {
+	return func(pc *ProxyClient) {
+		pc.endpoints = append([]ServerEndpoint(nil), endpoints...)
+		if order == ServerOrderRandom {
+			rand.Shuffle(len(pc.endpoints), func(i, j int) {
+				pc.endpoints[i], pc.endpoints[j] = pc.endpoints[j], pc.endpoints[i]
+			})
+		}
+	}
+}
+
+func (c *ProxyClient) serverEndpoints() []ServerEndpoint {
+	if len(c.endpoints) > 0 {
+		return c.endpoints
+	}
+	return []ServerEndpoint{{URL: c.serverUrl}}
+}
+
+func validateServerEndpoints(endpoints []ServerEndpoint) error {
+	for _, endpoint := range endpoints {
+		u, err := url.Parse(endpoint.URL)
+		if err != nil {
+			return fmt.Errorf("server endpoint %q: %w", endpoint.URL, err)
+		}
+		if u.Scheme != "ws" && u.Scheme != "wss" {
+			return fmt.Errorf("server endpoint %q: scheme must be ws or wss", endpoint.URL)
+		}
+		if u.Host == "" {
+			return fmt.Errorf("server endpoint %q: missing host", endpoint.URL)
+		}
+	}
+	return nil
+}
+
+// endpointDialer returns dialer with the TLS server name of endpoint.
+func endpointDialer(dialer *websocket.Dialer, endpoint ServerEndpoint) *websocket.Dialer {
+	if endpoint.ServerName == "" || dialer == nil {
+		return dialer
+	}
+	d := *dialer
+	if d.TLSClientConfig != nil {
+		d.TLSClientConfig = d.TLSClientConfig.Clone()
+	} else {
+		d.TLSClientConfig = &tls.Config{}
+	}
+	d.TLSClientConfig.ServerName = endpoint.ServerName
+	return &d
+}
+
+// watchPreferred checks every preferredServerCheckInterval whether endpoint
+// is reachable, and calls onReachable and returns once it is. It returns
+// without calling onReachable when ctx is cancelled.
+func (c *ProxyClient) watchPreferred(ctx context.Context, dialer *websocket.Dialer, endpoint ServerEndpoint, onReachable func()) {
+	for sleep(ctx, c.clock, preferredServerCheckInterval) {
+		if err := probe(ctx, endpointDialer(dialer, endpoint), endpoint.URL); err != nil {
+			logrus.Debugf("RDPClient: preferred server %s still unreachable: %v", endpoint.URL, err)
+			continue
+		}
+		logrus.Infof("RDPClient: preferred server %s is reachable again", endpoint.URL)
+		onReachable()
+		return
+	}
+}
+
+// probe checks that a TCP connection, through the proxy of dialer if any, and
+// for wss a TLS handshake, to the host of serverURL succeed, without opening a
+// session.
+func probe(ctx context.Context, dialer *websocket.Dialer, serverURL string) error {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "wss" {
+			port = "443"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, preferredServerCheckTimeout)
+	defer cancel()
+	proxyURL, err := proxyFor(dialer, u)
+	if err != nil {
+		return err
+	}
+	var conn net.Conn
+	if proxyURL != nil {
+		conn, err = dialProxy(ctx, proxyURL, host)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", host)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if u.Scheme != "wss" {
+		return nil
+	}
+
+	config := &tls.Config{}
+	if dialer != nil && dialer.TLSClientConfig != nil {
+		config = dialer.TLSClientConfig.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = u.Hostname()
+	}
+	return tls.Client(conn, config).HandshakeContext(ctx)
+}
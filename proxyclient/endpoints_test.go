@@ -0,0 +1,87 @@
+package proxyclient
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rancher/remotedialer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+func TestValidateServerEndpoints(t *testing.T) {
+	assert.NoError(t, validateServerEndpoints([]ServerEndpoint{{URL: "wss://proxy-a:5555/connect"}, {URL: "ws://127.0.0.1:5555/connect"}}))
+	assert.ErrorContains(t, validateServerEndpoints([]ServerEndpoint{{URL: "https://proxy-a/connect"}}), "scheme must be ws or wss")
+	assert.ErrorContains(t, validateServerEndpoints([]ServerEndpoint{{URL: "wss:///connect"}}), "missing host")
+}
+
+func TestEndpointDialer(t *testing.T) {
+	dialer := &websocket.Dialer{TLSClientConfig: &tls.Config{ServerName: "default-name"}}
+
+	assert.Same(t, dialer, endpointDialer(dialer, ServerEndpoint{URL: "wss://proxy-a/connect"}))
+
+	d := endpointDialer(dialer, ServerEndpoint{URL: "wss://proxy-b/connect", ServerName: "proxy-b-name"})
+	assert.Equal(t, "proxy-b-name", d.TLSClientConfig.ServerName)
+	assert.Equal(t, "default-name", dialer.TLSClientConfig.ServerName, "the shared dialer must not change")
+}
+
+func TestServerOrderRandom(t *testing.T) {
+	endpoints := []ServerEndpoint{{URL: "ws://a"}, {URL: "ws://b"}, {URL: "ws://c"}, {URL: "ws://d"}}
+	c := &ProxyClient{}
+	WithServerEndpoints(ServerOrderRandom, endpoints...)(c)
+	assert.ElementsMatch(t, endpoints, c.endpoints)
+	assert.Equal(t, "ws://a", endpoints[0].URL, "the caller's slice must not be shuffled")
+}
+
+func TestServerEndpointsFailover(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The preferred server is down at first
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	preferredAddr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	listener, err = net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	fallback := startTunnelServer(t, listener)
+
+	clock := testingclock.NewFakeClock(time.Now())
+	c := newTestClient(t, "",
+		withClock(clock),
+		WithOnConnectCallback(func(ctx context.Context, _ *remotedialer.Session) error {
+			<-ctx.Done()
+			return nil
+		}),
+		WithServerEndpoints(ServerOrderPreferred,
+			ServerEndpoint{URL: "ws://" + preferredAddr + "/connect"},
+			ServerEndpoint{URL: "ws://" + listener.Addr().String() + "/connect"},
+		))
+	go c.run(ctx)
+
+	require.Eventually(t, func() bool {
+		return fallback.HasSession("client-id")
+	}, 5*time.Second, 10*time.Millisecond, "client did not fail over")
+
+	// Nothing changes while the preferred server stays down
+	require.Eventually(t, clock.HasWaiters, time.Second, time.Millisecond)
+	clock.Step(preferredServerCheckInterval)
+	require.Eventually(t, clock.HasWaiters, time.Second, time.Millisecond)
+	assert.True(t, fallback.HasSession("client-id"))
+
+	listener, err = net.Listen("tcp", preferredAddr)
+	require.NoError(t, err)
+	preferred := startTunnelServer(t, listener)
+	clock.Step(preferredServerCheckInterval)
+
+	require.Eventually(t, func() bool {
+		return preferred.HasSession("client-id") && !fallback.HasSession("client-id")
+	}, 5*time.Second, 10*time.Millisecond, "client did not move back to the preferred server")
+	assert.Equal(t, PhaseConnected, c.State().Phase)
+}
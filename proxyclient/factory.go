@@ -0,0 +1,40 @@
+package proxyclient
+
+import (
+	"github.com/rancher/lasso/pkg/cache"
+	"github.com/rancher/lasso/pkg/client"
+	"github.com/rancher/wrangler/v3/pkg/generated/controllers/core"
+	"github.com/rancher/wrangler/v3/pkg/schemes"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+)
+
+// NewCoreFactory returns a core controller factory whose caches only watch
+// namespace, and whose Secret cache only holds certSecretName. Passing its
+// Secret controller to New keeps the client from caching every Secret in the
+// cluster and lets it run with a namespaced Role.
+func NewCoreFactory(restConfig *rest.Config, namespace, certSecretName string) (*core.Factory, error) {
+	clientFactory, err := client.NewSharedClientFactory(restConfig, &client.SharedClientFactoryOptions{
+		Scheme: schemes.All,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cacheFactory := cache.NewSharedCachedFactory(clientFactory, &cache.SharedCacheFactoryOptions{
+		DefaultNamespace: namespace,
+		KindTweakList: map[schema.GroupVersionKind]cache.TweakListOptionsFunc{
+			corev1.SchemeGroupVersion.WithKind("Secret"): func(opts *metav1.ListOptions) {
+				opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", certSecretName).String()
+			},
+		},
+	})
+
+	return core.NewFactoryFromConfigWithOptions(restConfig, &core.FactoryOptions{
+		Namespace:          namespace,
+		SharedCacheFactory: cacheFactory,
+	})
+}
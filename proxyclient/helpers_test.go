@@ -0,0 +1,57 @@
+package proxyclient
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rancher/remotedialer"
+	"github.com/stretchr/testify/require"
+	"k8s.io/utils/clock"
+)
+
+// startTunnelServer serves a remotedialer server on listener and returns it.
+func startTunnelServer(t *testing.T, listener net.Listener) *remotedialer.Server {
+	t.Helper()
+
+	server := remotedialer.New(func(*http.Request) (string, bool, error) {
+		return "client-id", true, nil
+	}, remotedialer.DefaultErrorWriter)
+	ts := httptest.NewUnstartedServer(server)
+	ts.Listener.Close()
+	ts.Listener = listener
+	ts.Start()
+	t.Cleanup(ts.Close)
+	return server
+}
+
+// startTunnel serves a remotedialer server on a random loopback port and
+// returns it with the URL clients connect to.
+func startTunnel(t *testing.T) (*remotedialer.Server, string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	return startTunnelServer(t, listener), "ws://" + listener.Addr().String() + "/connect"
+}
+
+// newTestClient builds a client through New that connects to serverURL with
+// a fakeForwarder and without the certificate Secret, so that tests get the
+// same defaults as real clients.
+func newTestClient(t *testing.T, serverURL string, opts ...ProxyClientOpt) *ProxyClient {
+	t.Helper()
+
+	opts = append([]ProxyClientOpt{WithServerURL(serverURL), WithoutSecretWatcher(), WithForwardedPorts(6666)}, opts...)
+	c, err := New(context.Background(), "secret", "test-namespace", "", "", nil, &fakeForwarder{}, opts...)
+	require.NoError(t, err)
+	return c
+}
+
+// withClock replaces the real clock, so that tests can step through waits.
+func withClock(clock clock.Clock) ProxyClientOpt {
+	return func(pc *ProxyClient) {
+		pc.clock = clock
+	}
+}
@@ -0,0 +1,173 @@
+package proxyclient
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	defaultLeaseDuration = 15 * time.Second
+	defaultRenewDeadline = 10 * time.Second
+	defaultRetryPeriod   = 2 * time.Second
+)
+
+// LeaderElection configures Lease based leader election between the replicas
+// of a consumer, so that only the leader holds the tunnel and port-forward.
+// A standby takes over at most LeaseDuration after the leader stops renewing
+// the Lease, or right away when the leader's Run context is cancelled.
+type LeaderElection struct {
+	Client    kubernetes.Interface
+	Namespace string // namespace of the Lease, defaults to the client namespace
+	Name      string // name of the Lease, shared by all replicas
+	Identity  string // identity of this replica, defaults to the hostname
+
+	LeaseDuration time.Duration // defaults to 15s
+	RenewDeadline time.Duration // defaults to 10s
+	RetryPeriod   time.Duration // defaults to 2s
+}
+
+// WithLeaderElection only opens the tunnel while this replica holds the
+// configured Lease.
+func WithLeaderElection(election LeaderElection) ProxyClientOpt {
+	return func(pc *ProxyClient) {
+		pc.leaderElection = &election
+	}
+}
+
+// WithOnLeadershipChange sets a callback run when this replica becomes or
+// stops being the leader. It requires WithLeaderElection.
+func WithOnLeadershipChange(onChange func(leader bool)) ProxyClientOpt {
+	return func(pc *ProxyClient) {
+		pc.onLeadershipChange = onChange
+	}
+}
+
+// IsLeader reports whether this replica currently holds the tunnel. It is
+// always true without leader election.
+func (c *ProxyClient) IsLeader() bool {
+	return c.leaderElection == nil || c.leader.Load()
+}
+
+func (c *ProxyClient) leaderElectionConfig() (leaderelection.LeaderElectionConfig, error) {
+	election := *c.leaderElection
+	if election.Client == nil {
+		return leaderelection.LeaderElectionConfig{}, fmt.Errorf("leader election requires a Kubernetes client")
+	}
+	if election.Name == "" {
+		return leaderelection.LeaderElectionConfig{}, fmt.Errorf("leader election requires a Lease name")
+	}
+	if election.Namespace == "" {
+		election.Namespace = c.namespace
+	}
+	if election.Identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return leaderelection.LeaderElectionConfig{}, fmt.Errorf("leader election identity: %w", err)
+		}
+		election.Identity = hostname
+	}
+	if election.LeaseDuration == 0 {
+		election.LeaseDuration = defaultLeaseDuration
+	}
+	if election.RenewDeadline == 0 {
+		election.RenewDeadline = defaultRenewDeadline
+	}
+	if election.RetryPeriod == 0 {
+		election.RetryPeriod = defaultRetryPeriod
+	}
+
+	return leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta: metav1.ObjectMeta{Namespace: election.Namespace, Name: election.Name},
+			Client:    election.Client.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{
+				Identity: election.Identity,
+			},
+		},
+		Name:            election.Name,
+		LeaseDuration:   election.LeaseDuration,
+		RenewDeadline:   election.RenewDeadline,
+		RetryPeriod:     election.RetryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				logrus.Infof("RDPClient: became leader of lease %s/%s", election.Namespace, election.Name)
+				c.setLeader(true)
+				c.run(ctx)
+			},
+			OnStoppedLeading: func() {
+				logrus.Infof("RDPClient: stopped leading lease %s/%s", election.Namespace, election.Name)
+				c.setLeader(false)
+			},
+			OnNewLeader: func(identity string) {
+				if identity != election.Identity {
+					logrus.Infof("RDPClient: lease %s/%s held by %s", election.Namespace, election.Name, identity)
+				}
+			},
+		},
+	}, nil
+}
+
+// runLeaderElection campaigns for the Lease until ctx is cancelled, running
+// the tunnel while leading and campaigning again after losing the Lease.
+func (c *ProxyClient) runLeaderElection(ctx context.Context) error {
+	config, err := c.leaderElectionConfig()
+	if err != nil {
+		return err
+	}
+	lead := config.Callbacks.OnStartedLeading
+
+	for ctx.Err() == nil {
+		// The elector does not wait for OnStartedLeading, which it runs in a
+		// goroutine, to return. Wait for the tunnel to close before campaigning
+		// again or returning, and never start it once the term is over.
+		var (
+			mu       sync.Mutex
+			over     bool
+			leading  bool
+			finished = make(chan struct{})
+		)
+		config.Callbacks.OnStartedLeading = func(leaderCtx context.Context) {
+			mu.Lock()
+			if over {
+				mu.Unlock()
+				return
+			}
+			leading = true
+			mu.Unlock()
+
+			defer close(finished)
+			lead(leaderCtx)
+		}
+		elector, err := leaderelection.NewLeaderElector(config)
+		if err != nil {
+			return fmt.Errorf("leader election: %w", err)
+		}
+		elector.Run(ctx)
+
+		mu.Lock()
+		over = true
+		wait := leading
+		mu.Unlock()
+		if wait {
+			<-finished
+			c.setLeader(false)
+		}
+	}
+	return nil
+}
+
+func (c *ProxyClient) setLeader(leader bool) {
+	if c.leader.Swap(leader) != leader && c.onLeadershipChange != nil {
+		c.onLeadershipChange(leader)
+	}
+}
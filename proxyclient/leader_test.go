@@ -0,0 +1,87 @@
+package proxyclient
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/clock"
+)
+
+type fakeForwarder struct {
+	started atomic.Int32
+	stopped atomic.Int32
+}
+
+func (f *fakeForwarder) Start() error {
+	f.started.Add(1)
+	return nil
+}
+
+func (f *fakeForwarder) Stop() {
+	f.stopped.Add(1)
+}
+
+func TestLeaderElectionValidation(t *testing.T) {
+	c := &ProxyClient{namespace: "test-namespace", leaderElection: &LeaderElection{Name: "tunnel"}}
+	_, err := c.leaderElectionConfig()
+	assert.ErrorContains(t, err, "requires a Kubernetes client")
+
+	c.leaderElection = &LeaderElection{Client: fake.NewClientset()}
+	_, err = c.leaderElectionConfig()
+	assert.ErrorContains(t, err, "requires a Lease name")
+
+	c.leaderElection = &LeaderElection{Client: fake.NewClientset(), Name: "tunnel", Identity: "replica-a"}
+	config, err := c.leaderElectionConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "replica-a", config.Lock.Identity())
+	assert.Equal(t, "test-namespace/tunnel", config.Lock.Describe())
+	assert.Equal(t, defaultLeaseDuration, config.LeaseDuration)
+	assert.True(t, config.ReleaseOnCancel)
+	assert.True(t, (&ProxyClient{}).IsLeader(), "clients without leader election always hold the tunnel")
+}
+
+func TestLeaderElectionFailover(t *testing.T) {
+	client := fake.NewClientset()
+
+	newReplica := func(identity string) (*ProxyClient, *atomic.Int32) {
+		var changes atomic.Int32
+		return &ProxyClient{
+			namespace: "test-namespace",
+			forwarder: &fakeForwarder{},
+			clock:     clock.RealClock{},
+			leaderElection: &LeaderElection{
+				Client:        client,
+				Name:          "tunnel",
+				Identity:      identity,
+				LeaseDuration: time.Second,
+				RenewDeadline: 500 * time.Millisecond,
+				RetryPeriod:   100 * time.Millisecond,
+			},
+			onLeadershipChange: func(bool) { changes.Add(1) },
+		}, &changes
+	}
+
+	ctxA, cancelA := context.WithCancel(context.Background())
+	defer cancelA()
+	replicaA, changesA := newReplica("replica-a")
+	require.NoError(t, replicaA.Start(ctxA))
+	require.Eventually(t, replicaA.IsLeader, 5*time.Second, 10*time.Millisecond, "replica-a did not become leader")
+
+	ctxB, cancelB := context.WithCancel(context.Background())
+	defer cancelB()
+	replicaB, _ := newReplica("replica-b")
+	require.NoError(t, replicaB.Start(ctxB))
+	assert.Never(t, replicaB.IsLeader, time.Second, 50*time.Millisecond, "replica-b took the lease while replica-a holds it")
+
+	// Stopping the leader releases the lease and the standby takes over
+	cancelA()
+	require.Eventually(t, replicaB.IsLeader, 5*time.Second, 10*time.Millisecond, "replica-b did not take over")
+	require.NoError(t, replicaA.Wait())
+	assert.False(t, replicaA.IsLeader())
+	assert.Equal(t, int32(2), changesA.Load(), "replica-a should report gaining and losing leadership")
+}
@@ -0,0 +1,103 @@
+package proxyclient
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
+	"github.com/rancher/wrangler/v3/pkg/generic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestLifecycleErrors(t *testing.T) {
+	c := &ProxyClient{}
+	assert.ErrorIs(t, c.Wait(), errNotStarted)
+
+	c.Stop()
+	c.Stop()
+	assert.ErrorIs(t, c.Start(context.Background()), errStopped)
+}
+
+// fakeSecretController calls the handlers registered with OnChange with
+// secret, and like lasso keeps them registered until their context is done.
+type fakeSecretController struct {
+	v1.SecretController
+	secret *corev1.Secret
+}
+
+func (f *fakeSecretController) OnChange(ctx context.Context, _ string, sync generic.ObjectHandler[*corev1.Secret]) {
+	go func() { <-ctx.Done() }()
+	_, _ = sync(f.secret.Namespace+"/"+f.secret.Name, f.secret)
+}
+
+// fakeConfigMapController is the ConfigMap counterpart of
+// fakeSecretController.
+type fakeConfigMapController struct {
+	v1.ConfigMapController
+	configMap *corev1.ConfigMap
+}
+
+func (f *fakeConfigMapController) OnChange(ctx context.Context, _ string, sync generic.ObjectHandler[*corev1.ConfigMap]) {
+	go func() { <-ctx.Done() }()
+	_, _ = sync(f.configMap.Namespace+"/"+f.configMap.Name, f.configMap)
+}
+
+func TestStopDoesNotLeak(t *testing.T) {
+	// The tunnel server outlives the client and is closed after the check
+	server, serverURL := startTunnel(t)
+	ignore := goleak.IgnoreCurrent()
+
+	// Every kind of CA source is watched
+	secrets := &fakeSecretController{secret: testCASecret(t, "ca")}
+	configMaps := &fakeConfigMapController{configMap: &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "extra-ca"},
+		Data:       map[string]string{"ca.crt": string(testCASecret(t, "configmap").Data[corev1.TLSCertKey])},
+	}}
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(path, testCASecret(t, "file").Data[corev1.TLSCertKey], 0o600))
+
+	forwarder := &fakeForwarder{}
+	c, err := New(context.Background(), "secret", "test-namespace", "ca", "", secrets, forwarder,
+		WithServerURL(serverURL),
+		WithForwardedPorts(6666),
+		WithCAFromConfigMap(configMaps, "extra-ca", "ca.crt"),
+		WithCAFromFile(path))
+	require.NoError(t, err)
+	require.NoError(t, c.Start(context.Background()))
+	assert.ErrorIs(t, c.Start(context.Background()), errAlreadyStarted)
+
+	require.Eventually(t, func() bool {
+		return server.HasSession("client-id")
+	}, 10*time.Second, 10*time.Millisecond, "client did not connect")
+	wait := make(chan error)
+	go func() { wait <- c.Wait() }()
+
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Stop()
+		}()
+	}
+	wg.Wait()
+
+	select {
+	case err := <-wait:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait did not return after Stop")
+	}
+	assert.NoError(t, c.Wait(), "Wait returns right away once stopped")
+	assert.Equal(t, forwarder.started.Load(), forwarder.stopped.Load(), "port-forward left running")
+
+	goleak.VerifyNone(t, ignore)
+}
@@ -0,0 +1,16 @@
+package proxyclient
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsNamespace = "remotedialer_proxy_client"
+
+var pinMismatches = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "public_key_pin_mismatches_total",
+		Help:      "Total count of proxy server certificates rejected for matching no pinned public key",
+	},
+)
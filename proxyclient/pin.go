@@ -0,0 +1,128 @@
+package proxyclient
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const pinPrefix = "sha256/"
+
+// pin is the SHA-256 of a DER encoded SubjectPublicKeyInfo.
+type pin [sha256.Size]byte
+
+func (p pin) String() string {
+	return pinPrefix + base64.StdEncoding.EncodeToString(p[:])
+}
+
+// WithPinnedPublicKeys only accepts proxy servers whose certificate chain
+// contains one of the given public keys, on top of chaining to the CA. Every
+// pin is the base64 SHA-256 of a SubjectPublicKeyInfo, optionally prefixed
+// with "sha256/". Several pins allow rotating keys.
+func WithPinnedPublicKeys(pins ...string) ProxyClientOpt {
+	return func(pc *ProxyClient) {
+		pc.pinnedPublicKeys = append(pc.pinnedPublicKeys, pins...)
+	}
+}
+
+// WithPinnedPublicKeysFromSecret reads more pins, as in WithPinnedPublicKeys,
+// from key of the certificate Secret, separated by commas or new lines. The
+// Secret is rejected while the key is missing or holds no pin.
+func WithPinnedPublicKeysFromSecret(key string) ProxyClientOpt {
+	return func(pc *ProxyClient) {
+		pc.pinSecretKey = key
+	}
+}
+
+func parsePin(s string) (pin, error) {
+	var p pin
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, pinPrefix))
+	if err != nil {
+		return p, fmt.Errorf("public key pin %q: %w", s, err)
+	}
+	if len(b) != len(p) {
+		return p, fmt.Errorf("public key pin %q: not a SHA-256 hash", s)
+	}
+	copy(p[:], b)
+	return p, nil
+}
+
+func parsePins(entries []string) ([]pin, error) {
+	var pins []pin
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		p, err := parsePin(entry)
+		if err != nil {
+			return nil, err
+		}
+		pins = append(pins, p)
+	}
+	return pins, nil
+}
+
+// pinsFromSecret returns the pins in the certificate Secret, if configured.
+func (c *ProxyClient) pinsFromSecret(secret *corev1.Secret) ([]pin, error) {
+	if c.pinSecretKey == "" {
+		return nil, nil
+	}
+	data, exists := secret.Data[c.pinSecretKey]
+	if !exists {
+		return nil, fmt.Errorf("secret %s/%s missing %s field", c.namespace, c.certSecretName, c.pinSecretKey)
+	}
+	pins, err := parsePins(strings.FieldsFunc(string(data), func(r rune) bool {
+		return r == ',' || r == '\n'
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("secret %s/%s: %w", c.namespace, c.certSecretName, err)
+	}
+	if len(pins) == 0 {
+		return nil, fmt.Errorf("secret %s/%s: no public key pin in %s field", c.namespace, c.certSecretName, c.pinSecretKey)
+	}
+	return pins, nil
+}
+
+func publicKeyPin(cert *x509.Certificate) pin {
+	return sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+}
+
+// verifyPins returns a tls.Config VerifyConnection that requires a certificate
+// of the verified chains to match one of pins, and then calls next if set.
+// Other certificates sent by the server prove nothing, so only the leaf is
+// checked when verification is skipped.
+func verifyPins(pins []pin, next func(tls.ConnectionState) error) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		var certs []*x509.Certificate
+		for _, chain := range cs.VerifiedChains {
+			certs = append(certs, chain...)
+		}
+		if len(cs.VerifiedChains) == 0 && len(cs.PeerCertificates) > 0 {
+			certs = cs.PeerCertificates[:1]
+		}
+		if !slices.ContainsFunc(certs, func(cert *x509.Certificate) bool {
+			return slices.Contains(pins, publicKeyPin(cert))
+		}) {
+			pinMismatches.Inc()
+			var got string
+			if len(cs.PeerCertificates) > 0 {
+				got = publicKeyPin(cs.PeerCertificates[0]).String()
+			}
+			err := fmt.Errorf("certificate of proxy server %s matches no pinned public key, its public key is %s", cs.ServerName, got)
+			logrus.Errorf("RDPClient: %v", err)
+			return err
+		}
+		if next != nil {
+			return next(cs)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,102 @@
+package proxyclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const otherPin = "sha256/47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="
+
+func TestParsePins(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		want    int
+		wantErr string
+	}{
+		{name: "prefixed", entries: []string{otherPin}, want: 1},
+		{name: "bare", entries: []string{"47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="}, want: 1},
+		{name: "blank entries skipped", entries: []string{" " + otherPin + " ", ""}, want: 1},
+		{name: "not base64", entries: []string{"sha256/not-base64!"}, wantErr: "illegal base64"},
+		{name: "not SHA-256", entries: []string{"sha256/AAAA"}, wantErr: "not a SHA-256 hash"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pins, err := parsePins(tt.entries)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, pins, tt.want)
+		})
+	}
+}
+
+func TestPinnedPublicKeys(t *testing.T) {
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	defer ts.Close()
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(ts.Certificate())
+	serverPin := publicKeyPin(ts.Certificate()).String()
+
+	tests := []struct {
+		name    string
+		pins    []string
+		wantErr string
+	}{
+		{name: "no pin"},
+		{name: "matching pin", pins: []string{serverPin}},
+		{name: "rotation", pins: []string{otherPin, serverPin}},
+		{name: "mismatch", pins: []string{otherPin}, wantErr: "matches no pinned public key, its public key is " + serverPin},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pins, err := parsePins(tt.pins)
+			require.NoError(t, err)
+			dialer := (&ProxyClient{}).buildDialer(rootCAs, pins)
+
+			mismatches := testutil.ToFloat64(pinMismatches)
+			conn, err := tls.Dial("tcp", ts.Listener.Addr().String(), dialer.TLSClientConfig)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				assert.Equal(t, mismatches+1, testutil.ToFloat64(pinMismatches))
+				return
+			}
+			require.NoError(t, err)
+			conn.Close()
+			assert.Equal(t, mismatches, testutil.ToFloat64(pinMismatches))
+		})
+	}
+}
+
+func TestPinsFromSecret(t *testing.T) {
+	c := &ProxyClient{namespace: "test-namespace", certSecretName: "ca", pinSecretKey: "pins"}
+	secret := func(data map[string][]byte) *corev1.Secret {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "ca"}, Data: data}
+	}
+
+	pins, err := c.pinsFromSecret(secret(map[string][]byte{
+		"pins": []byte("47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=,\nsha256/LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=\n"),
+	}))
+	require.NoError(t, err)
+	assert.Len(t, pins, 2)
+
+	_, err = c.pinsFromSecret(secret(nil))
+	assert.ErrorContains(t, err, "secret test-namespace/ca missing pins field")
+
+	_, err = c.pinsFromSecret(secret(map[string][]byte{"pins": []byte("\n")}))
+	assert.ErrorContains(t, err, "no public key pin in pins field")
+
+	_, err = c.pinsFromSecret(secret(map[string][]byte{"pins": []byte("sha256/AAAA")}))
+	assert.ErrorContains(t, err, "secret test-namespace/ca: public key pin")
+}
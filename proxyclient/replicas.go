@@ -0,0 +1,183 @@
+package proxyclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const replicaSyncInterval = 10 * time.Second
+
+var errNoReplicas = errors.New("no proxy replica found")
+
+// Replica is a proxy replica the client keeps a session with.
+type Replica struct {
+	Name     string // unique name of the replica, such as its Pod name
+	Endpoint ServerEndpoint
+	// Forwarder, when set, is started before connecting to Endpoint, for
+	// example a port-forward to this replica.
+	Forwarder PortForwarder
+}
+
+// ReplicaSource discovers the proxy replicas.
+type ReplicaSource interface {
+	Replicas(ctx context.Context) ([]Replica, error)
+}
+
+// WithReplicaSessions keeps a session with every replica found by source,
+// instead of a single tunnel through the PortForwarder passed to New, so that
+// whichever replica a connection reaches already holds a local tunnel.
+// Replicas are looked up every 10 seconds and sessions opened and closed as
+// they come and go. State reports the most connected session.
+func WithReplicaSessions(source ReplicaSource) ProxyClientOpt {
+	return func(pc *ProxyClient) {
+		pc.replicas = source
+	}
+}
+
+type replicaSession struct {
+	replica Replica
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func (c *ProxyClient) runReplicas(ctx context.Context) {
+	if !c.waitForDialer(ctx, "") {
+		return
+	}
+
+	sessions := map[string]*replicaSession{}
+	defer func() {
+		for name, session := range sessions {
+			c.closeReplicaSession(name, session)
+		}
+	}()
+
+	for {
+		c.syncReplicas(ctx, sessions)
+		if !sleep(ctx, c.clock, replicaSyncInterval) {
+			return
+		}
+	}
+}
+
+func (c *ProxyClient) syncReplicas(ctx context.Context, sessions map[string]*replicaSession) {
+	replicas, err := c.replicas.Replicas(ctx)
+	if err != nil {
+		logrus.Errorf("RDPClient: listing proxy replicas failed: %v", err)
+		if c.onError != nil {
+			c.onError(err)
+		}
+		return
+	}
+
+	found := map[string]Replica{}
+	for _, replica := range replicas {
+		if replica.Name != "" {
+			found[replica.Name] = replica
+		}
+	}
+
+	for name, session := range sessions {
+		if replica, ok := found[name]; ok && replica.Endpoint == session.replica.Endpoint {
+			continue
+		}
+		logrus.Infof("RDPClient: closing session to proxy replica %s", name)
+		c.closeReplicaSession(name, session)
+		delete(sessions, name)
+	}
+
+	for name, replica := range found {
+		if _, ok := sessions[name]; ok {
+			continue
+		}
+		logrus.Infof("RDPClient: opening session to proxy replica %s at %s", name, replica.Endpoint.URL)
+		forwarder := replica.Forwarder
+		if forwarder == nil {
+			forwarder = noopForwarder{}
+		}
+		sessionCtx, cancel := context.WithCancel(ctx)
+		session := &replicaSession{replica: replica, cancel: cancel, done: make(chan struct{})}
+		sessions[name] = session
+		go func() {
+			defer close(session.done)
+			c.connect(sessionCtx, name, forwarder, []ServerEndpoint{replica.Endpoint})
+		}()
+	}
+
+	if len(sessions) == 0 {
+		c.setState(PhaseBackingOff, errNoReplicas)
+	} else {
+		c.removeSessionState("")
+	}
+}
+
+func (c *ProxyClient) closeReplicaSession(name string, session *replicaSession) {
+	session.cancel()
+	<-session.done
+	c.removeSessionState(name)
+}
+
+type noopForwarder struct{}
+
+func (noopForwarder) Start() error { return nil }
+func (noopForwarder) Stop()        {}
+
+// EndpointSliceLister lists EndpointSlices, such as the cache of a wrangler
+// EndpointSlice controller.
+type EndpointSliceLister interface {
+	List(namespace string, selector labels.Selector) ([]*discoveryv1.EndpointSlice, error)
+}
+
+type endpointSliceReplicas struct {
+	endpointSlices EndpointSliceLister
+	namespace      string
+	service        string
+	port           int
+}
+
+// EndpointSliceReplicas finds the ready Pods of the proxy Service from its
+// EndpointSlices, for clients running in the proxy cluster to connect to
+// every replica directly on port. The certificate of the replicas is verified
+// against the certServerName passed to New.
+func EndpointSliceReplicas(endpointSlices EndpointSliceLister, namespace, service string, port int) ReplicaSource {
+	return &endpointSliceReplicas{endpointSlices: endpointSlices, namespace: namespace, service: service, port: port}
+}
+
+func (e *endpointSliceReplicas) Replicas(context.Context) ([]Replica, error) {
+	endpointSlices, err := e.endpointSlices.List(e.namespace, labels.SelectorFromSet(labels.Set{discoveryv1.LabelServiceName: e.service}))
+	if err != nil {
+		return nil, err
+	}
+
+	var replicas []Replica
+	for _, slice := range endpointSlices {
+		for _, endpoint := range slice.Endpoints {
+			if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+				continue
+			}
+			if len(endpoint.Addresses) == 0 {
+				continue
+			}
+			address := endpoint.Addresses[0]
+			name := address
+			if endpoint.TargetRef != nil && endpoint.TargetRef.Name != "" {
+				name = endpoint.TargetRef.Name
+			}
+			replicas = append(replicas, Replica{
+				Name: name,
+				Endpoint: ServerEndpoint{
+					URL: fmt.Sprintf("wss://%s%s", net.JoinHostPort(address, strconv.Itoa(e.port)), defaultServerPath),
+				},
+			})
+		}
+	}
+	return replicas, nil
+}
@@ -0,0 +1,137 @@
+package proxyclient
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rancher/remotedialer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+type fakeReplicas struct {
+	mu       sync.Mutex
+	replicas []Replica
+}
+
+func (f *fakeReplicas) Replicas(context.Context) ([]Replica, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.replicas, nil
+}
+
+func (f *fakeReplicas) set(replicas ...Replica) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.replicas = replicas
+}
+
+func TestReplicaSessions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	servers := map[string]*remotedialer.Server{}
+	var replicas []Replica
+	for _, name := range []string{"proxy-0", "proxy-1"} {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		servers[name] = startTunnelServer(t, listener)
+		replicas = append(replicas, Replica{
+			Name:      name,
+			Endpoint:  ServerEndpoint{URL: "ws://" + listener.Addr().String() + "/connect"},
+			Forwarder: &fakeForwarder{},
+		})
+	}
+	source := &fakeReplicas{}
+	source.set(replicas...)
+
+	clock := testingclock.NewFakeClock(time.Now())
+	c := &ProxyClient{
+		dialer:   &websocket.Dialer{},
+		replicas: source,
+		clock:    clock,
+		tracer:   otel.Tracer(tracerName),
+	}
+	go c.run(ctx)
+
+	require.Eventually(t, func() bool {
+		return servers["proxy-0"].HasSession("client-id") && servers["proxy-1"].HasSession("client-id")
+	}, 5*time.Second, 10*time.Millisecond, "client did not connect to every replica")
+	assert.Equal(t, PhaseConnected, c.State().Phase)
+
+	// proxy-1 goes away
+	source.set(replicas[0])
+	require.Eventually(t, clock.HasWaiters, time.Second, time.Millisecond)
+	clock.Step(replicaSyncInterval)
+	require.Eventually(t, func() bool {
+		return !servers["proxy-1"].HasSession("client-id")
+	}, 5*time.Second, 10*time.Millisecond, "session to the removed replica was not closed")
+	assert.True(t, servers["proxy-0"].HasSession("client-id"))
+	assert.Positive(t, replicas[1].Forwarder.(*fakeForwarder).stopped.Load(), "port-forward to the removed replica should stop")
+	assert.Equal(t, PhaseConnected, c.State().Phase)
+
+	// No replica left
+	source.set()
+	require.Eventually(t, clock.HasWaiters, time.Second, time.Millisecond)
+	clock.Step(replicaSyncInterval)
+	require.Eventually(t, func() bool {
+		return c.State().Phase == PhaseBackingOff
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.ErrorIs(t, c.State().LastError, errNoReplicas)
+	assert.False(t, servers["proxy-0"].HasSession("client-id"))
+}
+
+type fakeEndpointSlices []*discoveryv1.EndpointSlice
+
+func (f fakeEndpointSlices) List(namespace string, selector labels.Selector) ([]*discoveryv1.EndpointSlice, error) {
+	var result []*discoveryv1.EndpointSlice
+	for _, slice := range f {
+		if slice.Namespace == namespace && selector.Matches(labels.Set(slice.Labels)) {
+			result = append(result, slice)
+		}
+	}
+	return result, nil
+}
+
+func TestEndpointSliceReplicas(t *testing.T) {
+	ready, notReady := true, false
+	slices := fakeEndpointSlices{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "cattle-system",
+				Name:      "proxy-abc",
+				Labels:    map[string]string{discoveryv1.LabelServiceName: "proxy"},
+			},
+			Endpoints: []discoveryv1.Endpoint{
+				{Addresses: []string{"10.0.0.1"}, Conditions: discoveryv1.EndpointConditions{Ready: &ready}, TargetRef: &corev1.ObjectReference{Kind: "Pod", Name: "proxy-0"}},
+				{Addresses: []string{"10.0.0.2"}, Conditions: discoveryv1.EndpointConditions{Ready: &notReady}, TargetRef: &corev1.ObjectReference{Kind: "Pod", Name: "proxy-1"}},
+				{Addresses: []string{"fd00::3"}},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "cattle-system",
+				Name:      "other-abc",
+				Labels:    map[string]string{discoveryv1.LabelServiceName: "other"},
+			},
+			Endpoints: []discoveryv1.Endpoint{{Addresses: []string{"10.0.1.1"}}},
+		},
+	}
+
+	replicas, err := EndpointSliceReplicas(slices, "cattle-system", "proxy", 5555).Replicas(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []Replica{
+		{Name: "proxy-0", Endpoint: ServerEndpoint{URL: "wss://10.0.0.1:5555/connect"}},
+		{Name: "fd00::3", Endpoint: ServerEndpoint{URL: "wss://[fd00::3]:5555/connect"}},
+	}, replicas)
+}
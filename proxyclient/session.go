@@ -0,0 +1,94 @@
+package proxyclient
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// sessionConn ends a remotedialer session by closing its websocket
+// connection. remotedialer.ConnectToProxy only synchronizes with the session
+// it serves when the session fails on its own: returning because its context
+// was cancelled or because onConnect failed races with the session's pings.
+// Sessions are therefore never ended either way, but by failing them.
+type sessionConn struct {
+	ctx    context.Context // cancelled on close, aborting a dial in progress
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	conn   net.Conn
+	closed bool
+	err    error
+}
+
+func newSessionConn() *sessionConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &sessionConn{ctx: ctx, cancel: cancel}
+}
+
+// dialer returns a copy of dialer whose connection s closes.
+func (s *sessionConn) dialer(dialer *websocket.Dialer) *websocket.Dialer {
+	d := *dialer
+	dial := d.NetDialContext
+	if dial == nil && d.NetDial != nil {
+		dial = func(_ context.Context, network, addr string) (net.Conn, error) {
+			return dialer.NetDial(network, addr)
+		}
+	}
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	d.NetDialContext = s.track(dial)
+	if d.NetDialTLSContext != nil {
+		d.NetDialTLSContext = s.track(d.NetDialTLSContext)
+	}
+	return &d
+}
+
+func (s *sessionConn) track(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		defer context.AfterFunc(s.ctx, cancel)()
+
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.closed {
+			conn.Close()
+			return nil, net.ErrClosed
+		}
+		s.conn = conn
+		return conn, nil
+	}
+}
+
+// close ends the session, or the attempt to open it, with err. Only the first
+// call has an effect.
+func (s *sessionConn) close(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.err = err
+	s.cancel()
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
+
+// closedWith returns whether the session was ended by close, and the error it
+// was closed with.
+func (s *sessionConn) closedWith() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed, s.err
+}
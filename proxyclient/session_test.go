@@ -0,0 +1,38 @@
+package proxyclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionConnClose(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	session := newSessionConn()
+	dial := session.dialer(&websocket.Dialer{}).NetDialContext
+	conn, err := dial(context.Background(), "tcp", l.Addr().String())
+	require.NoError(t, err)
+
+	closed, _ := session.closedWith()
+	assert.False(t, closed)
+
+	session.close(errors.New("first"))
+	session.close(errors.New("second"))
+	closed, err = session.closedWith()
+	assert.True(t, closed)
+	assert.EqualError(t, err, "first", "only the first close counts")
+
+	_, err = conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, net.ErrClosed, "close ends the connection")
+
+	_, err = dial(context.Background(), "tcp", l.Addr().String())
+	assert.Error(t, err, "no connection is dialed once closed")
+}
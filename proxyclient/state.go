@@ -0,0 +1,185 @@
+package proxyclient
+
+import (
+	"context"
+	"time"
+)
+
+// Phase is the step of the connect loop a ProxyClient is in.
+type Phase string
+
+const (
+	// PhaseWaitingForCert waits for the certificate Secret to build a dialer.
+	PhaseWaitingForCert Phase = "waiting-for-cert"
+	// PhasePortForwarding starts the port-forward to the proxy.
+	PhasePortForwarding Phase = "port-forwarding"
+	// PhaseConnecting opens the remotedialer session.
+	PhaseConnecting Phase = "connecting"
+	// PhaseConnected holds an open remotedialer session.
+	PhaseConnected Phase = "connected"
+	// PhaseBackingOff waits before the next attempt after a failure.
+	PhaseBackingOff Phase = "backing-off"
+)
+
+// State is the connection state of a ProxyClient.
+type State struct {
+	Phase Phase
+	// LastError is the error of the last failed attempt, or nil once a
+	// session is connected.
+	LastError error
+	// Since is when the client entered Phase.
+	Since time.Time
+}
+
+// WithOnDisconnect sets a callback run when a connected session ends, with
+// the error it ended with, or nil when the client closed it to move back to
+// its preferred server endpoint or because the trusted CA changed.
+func WithOnDisconnect(onDisconnect func(err error)) ProxyClientOpt {
+	return func(pc *ProxyClient) {
+		pc.onDisconnect = onDisconnect
+	}
+}
+
+// WithOnError sets a callback run for every failure of the connect loop:
+// building the dialer from the certificate Secret, starting the port-forward,
+// connecting to the proxy or losing a session.
+func WithOnError(onError func(err error)) ProxyClientOpt {
+	return func(pc *ProxyClient) {
+		pc.onError = onError
+	}
+}
+
+// State returns the current connection state.
+func (c *ProxyClient) State() State {
+	c.stateMtx.Lock()
+	defer c.stateMtx.Unlock()
+	return c.state
+}
+
+// Subscribe returns a channel receiving the current state and then every
+// state change, until ctx is cancelled and the channel is closed. A slow
+// reader only misses intermediate states: the channel always ends up holding
+// the latest one.
+func (c *ProxyClient) Subscribe(ctx context.Context) <-chan State {
+	ch := make(chan State, 1)
+
+	c.stateMtx.Lock()
+	if c.subscribers == nil {
+		c.subscribers = map[chan State]struct{}{}
+	}
+	c.subscribers[ch] = struct{}{}
+	ch <- c.state
+	c.stateMtx.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		c.stateMtx.Lock()
+		delete(c.subscribers, ch)
+		close(ch)
+		c.stateMtx.Unlock()
+	}()
+
+	return ch
+}
+
+// phaseRank orders phases from the least to the most connected, to report
+// the most connected session when the client holds several.
+var phaseRank = map[Phase]int{
+	PhaseWaitingForCert: 0,
+	PhaseBackingOff:     1,
+	PhasePortForwarding: 2,
+	PhaseConnecting:     3,
+	PhaseConnected:      4,
+}
+
+// sessionState is the state of one connect loop, keyed by session name in
+// ProxyClient.sessions. The loop of a single tunnel uses the empty name.
+type sessionState struct {
+	State
+	version uint64
+}
+
+func (c *ProxyClient) setState(phase Phase, err error) {
+	c.setSessionState("", phase, err)
+}
+
+func (c *ProxyClient) setSessionState(name string, phase Phase, err error) {
+	c.stateMtx.Lock()
+	defer c.stateMtx.Unlock()
+	c.updateState(name, phase, err)
+}
+
+// setSessionStateFrom only moves to phase when the session is still in from,
+// so that a late callback cannot undo a more recent transition.
+func (c *ProxyClient) setSessionStateFrom(name string, from, phase Phase, err error) bool {
+	c.stateMtx.Lock()
+	defer c.stateMtx.Unlock()
+	if c.sessions[name].Phase != from {
+		return false
+	}
+	c.updateState(name, phase, err)
+	return true
+}
+
+func (c *ProxyClient) sessionState(name string) State {
+	c.stateMtx.Lock()
+	defer c.stateMtx.Unlock()
+	return c.sessions[name].State
+}
+
+func (c *ProxyClient) removeSessionState(name string) {
+	c.stateMtx.Lock()
+	defer c.stateMtx.Unlock()
+	delete(c.sessions, name)
+	c.publishState()
+}
+
+func (c *ProxyClient) updateState(name string, phase Phase, err error) {
+	session, ok := c.sessions[name]
+	if ok && session.Phase == phase && session.LastError == nil && err == nil {
+		return
+	}
+	if c.sessions == nil {
+		c.sessions = map[string]sessionState{}
+	}
+	c.version++
+	c.sessions[name] = sessionState{
+		State:   State{Phase: phase, LastError: err, Since: c.clock.Now()},
+		version: c.version,
+	}
+	c.publishState()
+}
+
+// publishState sets the client state to the most connected session, the most
+// recently updated one on ties, and sends it to subscribers when it changed.
+func (c *ProxyClient) publishState() {
+	var latest sessionState
+	for _, session := range c.sessions {
+		rank, latestRank := phaseRank[session.Phase], phaseRank[latest.Phase]
+		if latest.version == 0 || rank > latestRank || rank == latestRank && session.version > latest.version {
+			latest = session
+		}
+	}
+	if latest.version == 0 || latest.version == c.stateVersion {
+		return
+	}
+	c.state, c.stateVersion = latest.State, latest.version
+
+	for ch := range c.subscribers {
+		// Replace an unread state with the latest one
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- c.state
+	}
+}
+
+// fail records a failed attempt of a session and moves it to
+// PhaseBackingOff.
+func (c *ProxyClient) fail(name string, err error) {
+	if c.onError != nil {
+		c.onError(err)
+	}
+	c.setSessionState(name, PhaseBackingOff, err)
+}
@@ -0,0 +1,80 @@
+package proxyclient
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rancher/remotedialer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+func TestSubscribe(t *testing.T) {
+	c := &ProxyClient{clock: testingclock.NewFakeClock(time.Now())}
+	c.setState(PhaseWaitingForCert, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	states := c.Subscribe(ctx)
+	assert.Equal(t, PhaseWaitingForCert, (<-states).Phase)
+
+	// Unread states are replaced by the latest one
+	err := errors.New("port-forward failed")
+	c.setState(PhasePortForwarding, nil)
+	c.setState(PhaseBackingOff, err)
+	state := <-states
+	assert.Equal(t, PhaseBackingOff, state.Phase)
+	assert.Equal(t, err, state.LastError)
+	assert.Equal(t, state, c.State())
+
+	assert.False(t, c.setSessionStateFrom("", PhaseConnecting, PhaseConnected, nil), "late transitions are ignored")
+	assert.Equal(t, PhaseBackingOff, c.State().Phase)
+
+	cancel()
+	require.Eventually(t, func() bool {
+		_, open := <-states
+		return !open
+	}, time.Second, time.Millisecond, "channel not closed with its context")
+}
+
+func TestRunReportsState(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, serverURL := startTunnel(t)
+
+	disconnect := make(chan struct{})
+	var disconnectErr, lastErr atomic.Pointer[error]
+	c := newTestClient(t, serverURL,
+		WithBackoff(Backoff{InitialInterval: time.Hour}),
+		WithOnConnectCallback(func(ctx context.Context, _ *remotedialer.Session) error {
+			select {
+			case <-disconnect:
+				return errors.New("closed by test")
+			case <-ctx.Done():
+				return nil
+			}
+		}),
+		WithOnDisconnect(func(err error) { disconnectErr.Store(&err) }),
+		WithOnError(func(err error) { lastErr.Store(&err) }))
+	go c.run(ctx)
+
+	require.Eventually(t, func() bool {
+		return c.State().Phase == PhaseConnected
+	}, 5*time.Second, 10*time.Millisecond, "client did not connect")
+	assert.NoError(t, c.State().LastError)
+	assert.Nil(t, disconnectErr.Load())
+
+	close(disconnect)
+	require.Eventually(t, func() bool {
+		return c.State().Phase == PhaseBackingOff
+	}, 5*time.Second, 10*time.Millisecond, "client did not back off after the session ended")
+	assert.EqualError(t, c.State().LastError, "closed by test")
+	require.NotNil(t, disconnectErr.Load())
+	assert.EqualError(t, *disconnectErr.Load(), "closed by test")
+	require.NotNil(t, lastErr.Load())
+	assert.EqualError(t, *lastErr.Load(), "closed by test")
+}
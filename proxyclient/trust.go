@@ -0,0 +1,333 @@
+package proxyclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	v1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// trustSource is the part of the trust bundle read from one source.
+type trustSource struct {
+	certs []*x509.Certificate
+	pins  []pin
+}
+
+type configMapCASource struct {
+	configMaps v1.ConfigMapController
+	name       string
+	key        string
+}
+
+// WithCAKey reads the CA from key of the certificate Secret, for example
+// "ca.crt", instead of "tls.crt".
+func WithCAKey(key string) ProxyClientOpt {
+	return func(pc *ProxyClient) {
+		pc.caKey = key
+	}
+}
+
+// WithCAFromConfigMap adds the PEM certificates in key of the ConfigMap name,
+// in the client namespace, to the trust bundle. New accepts an empty
+// certSecretName when the CA only comes from ConfigMaps or files.
+func WithCAFromConfigMap(configMaps v1.ConfigMapController, name, key string) ProxyClientOpt {
+	return func(pc *ProxyClient) {
+		pc.caConfigMaps = append(pc.caConfigMaps, configMapCASource{configMaps: configMaps, name: name, key: key})
+	}
+}
+
+// WithCAFromFile adds the PEM certificates in the file at path to the trust
+// bundle. The file is read again every 10 seconds, so that a mounted Secret
+// or ConfigMap can be updated.
+func WithCAFromFile(path string) ProxyClientOpt {
+	return func(pc *ProxyClient) {
+		pc.caFiles = append(pc.caFiles, path)
+	}
+}
+
+func (c *ProxyClient) hasCASources() bool {
+	return len(c.caConfigMaps) > 0 || len(c.caFiles) > 0
+}
+
+func (c *ProxyClient) secretSource() string {
+	return "secret " + c.namespace + "/" + c.certSecretName
+}
+
+// watchTrustSources loads every source of the trust bundle and keeps the
+// dialer trusting their merged certificates. Sessions are only opened once all
+// of them are loaded.
+func (c *ProxyClient) watchTrustSources(ctx context.Context, watchSecret bool, secretController v1.SecretController) {
+	if watchSecret {
+		c.trustSources = append(c.trustSources, c.secretSource())
+	}
+	for _, source := range c.caConfigMaps {
+		c.trustSources = append(c.trustSources, "configmap "+c.namespace+"/"+source.name)
+	}
+	for _, path := range c.caFiles {
+		c.trustSources = append(c.trustSources, "file "+path)
+	}
+
+	if watchSecret {
+		secretController.OnChange(ctx, c.certSecretName, c.onSecretChange)
+	}
+	for _, source := range c.caConfigMaps {
+		source.configMaps.OnChange(ctx, source.name, c.onConfigMapChange(source))
+	}
+	for _, path := range c.caFiles {
+		c.watchers.Add(1)
+		go func() {
+			defer c.watchers.Done()
+			c.watchCAFile(ctx, path)
+		}()
+	}
+}
+
+func (c *ProxyClient) onSecretChange(key string, newSecret *corev1.Secret) (*corev1.Secret, error) {
+	if newSecret == nil || newSecret.DeletionTimestamp != nil {
+		if key == c.namespace+"/"+c.certSecretName {
+			c.setTrustSource(c.secretSource(), nil)
+		}
+		return newSecret, nil
+	}
+
+	if newSecret.Name == c.certSecretName && newSecret.Namespace == c.namespace {
+		certs, err := certificatesFromKey(c.secretSource(), c.caKey, newSecret.Data)
+		var pins []pin
+		if err == nil {
+			pins, err = c.pinsFromSecret(newSecret)
+		}
+		if err != nil {
+			c.trustError(err)
+			return nil, err
+		}
+		c.setTrustSource(c.secretSource(), &trustSource{certs: certs, pins: pins})
+	}
+
+	return newSecret, nil
+}
+
+func (c *ProxyClient) onConfigMapChange(source configMapCASource) func(string, *corev1.ConfigMap) (*corev1.ConfigMap, error) {
+	name := "configmap " + c.namespace + "/" + source.name
+	return func(key string, configMap *corev1.ConfigMap) (*corev1.ConfigMap, error) {
+		if configMap == nil || configMap.DeletionTimestamp != nil {
+			if key == c.namespace+"/"+source.name {
+				c.setTrustSource(name, nil)
+			}
+			return configMap, nil
+		}
+		if configMap.Name != source.name || configMap.Namespace != c.namespace {
+			return configMap, nil
+		}
+
+		data := map[string][]byte{}
+		for k, v := range configMap.BinaryData {
+			data[k] = v
+		}
+		for k, v := range configMap.Data {
+			data[k] = []byte(v)
+		}
+		certs, err := certificatesFromKey(name, source.key, data)
+		if err != nil {
+			c.trustError(err)
+			return nil, err
+		}
+		c.setTrustSource(name, &trustSource{certs: certs})
+		return configMap, nil
+	}
+}
+
+// watchCAFile reads the file at path every certificateWatchInterval until ctx
+// is done. A missing file removes its certificates, an invalid one keeps them.
+func (c *ProxyClient) watchCAFile(ctx context.Context, path string) {
+	name := "file " + path
+	var last []byte
+	for {
+		data, err := os.ReadFile(path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			if last != nil {
+				c.setTrustSource(name, nil)
+			}
+			last = nil
+		case err != nil:
+			c.trustError(fmt.Errorf("failed to read %s: %w", name, err))
+		case last == nil || !bytes.Equal(data, last):
+			last = data
+			certs, err := parseCertificates(name, data)
+			if err != nil {
+				c.trustError(err)
+				break
+			}
+			c.setTrustSource(name, &trustSource{certs: certs})
+		}
+
+		if !sleep(ctx, c.clock, certificateWatchInterval) {
+			return
+		}
+	}
+}
+
+func (c *ProxyClient) trustError(err error) {
+	logrus.Errorf("RDPClient: loading CA failed, keeping the previous one: %s", err.Error())
+	if c.onError != nil {
+		c.onError(err)
+	}
+}
+
+func certificatesFromKey(source, key string, data map[string][]byte) ([]*x509.Certificate, error) {
+	if key == "" {
+		key = corev1.TLSCertKey
+	}
+	pemData, exists := data[key]
+	if !exists {
+		return nil, fmt.Errorf("%s missing %s field", source, key)
+	}
+	return parseCertificates(key+" field of "+source, pemData)
+}
+
+func parseCertificates(source string, pemData []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, pemData = pem.Decode(pemData)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", source, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("failed to parse %s into a CA pool", source)
+	}
+	return certs, nil
+}
+
+// setTrustSource replaces the part of the trust bundle read from source, or
+// removes it when trust is nil, logs which certificates are added or removed,
+// and rebuilds the dialer from the merged bundle. The dialer is cleared while
+// a source is missing.
+func (c *ProxyClient) setTrustSource(source string, trust *trustSource) {
+	c.trustMtx.Lock()
+	defer c.trustMtx.Unlock()
+
+	before := c.trustedCertificates()
+	if trust == nil {
+		if _, loaded := c.trust[source]; loaded {
+			logrus.Warnf("RDPClient: %s deleted, pausing until it is available again", source)
+		}
+		delete(c.trust, source)
+	} else {
+		if c.trust == nil {
+			c.trust = map[string]trustSource{}
+		}
+		c.trust[source] = *trust
+	}
+	after := c.trustedCertificates()
+	logTrustChanges(before, after, c.clock.Now())
+
+	missing := len(c.trust) == 0
+	for _, name := range c.trustSources {
+		if _, loaded := c.trust[name]; !loaded {
+			missing = true
+		}
+	}
+	if missing {
+		c.clearDialer()
+		return
+	}
+
+	var fingerprints []string
+	for fingerprint := range after {
+		fingerprints = append(fingerprints, fingerprint)
+	}
+	slices.Sort(fingerprints)
+
+	rootCAs := x509.NewCertPool()
+	for _, fingerprint := range fingerprints {
+		rootCAs.AddCert(after[fingerprint].cert)
+	}
+	pins := slices.Clip(c.pins)
+	for _, name := range c.loadedSources() {
+		pins = append(pins, c.trust[name].pins...)
+	}
+
+	// Sessions are told to reconnect when this changes
+	var caData strings.Builder
+	for _, fingerprint := range fingerprints {
+		caData.WriteString(fingerprint + "\n")
+	}
+	for _, p := range pins {
+		caData.WriteString(p.String() + "\n")
+	}
+	c.setDialer(c.buildDialer(rootCAs, pins), []byte(caData.String()))
+}
+
+type trustedCertificate struct {
+	cert   *x509.Certificate
+	source string
+}
+
+// trustedCertificates returns the certificates of every loaded source by
+// SHA-256 fingerprint.
+func (c *ProxyClient) trustedCertificates() map[string]trustedCertificate {
+	certs := map[string]trustedCertificate{}
+	for _, name := range c.loadedSources() {
+		for _, cert := range c.trust[name].certs {
+			fingerprint := certificateFingerprint(cert)
+			if _, exists := certs[fingerprint]; !exists {
+				certs[fingerprint] = trustedCertificate{cert: cert, source: name}
+			}
+		}
+	}
+	return certs
+}
+
+func (c *ProxyClient) loadedSources() []string {
+	var names []string
+	for name := range c.trust {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func logTrustChanges(before, after map[string]trustedCertificate, now time.Time) {
+	for fingerprint, trusted := range after {
+		if _, exists := before[fingerprint]; exists {
+			continue
+		}
+		logrus.Infof("RDPClient: trusting CA %q (sha256 %s) from %s", trusted.cert.Subject.String(), fingerprint, trusted.source)
+		if now.After(trusted.cert.NotAfter) {
+			logrus.Warnf("RDPClient: CA %q from %s expired on %s", trusted.cert.Subject.String(), trusted.source, trusted.cert.NotAfter.Format(time.RFC3339))
+		}
+	}
+	for fingerprint, trusted := range before {
+		if _, exists := after[fingerprint]; !exists {
+			logrus.Infof("RDPClient: no longer trusting CA %q (sha256 %s) from %s", trusted.cert.Subject.String(), fingerprint, trusted.source)
+		}
+	}
+}
+
+func certificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
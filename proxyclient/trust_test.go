@@ -0,0 +1,133 @@
+package proxyclient
+
+import (
+	"context"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/clock"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+func certPool(t *testing.T, pemData ...[]byte) *x509.CertPool {
+	t.Helper()
+
+	pool := x509.NewCertPool()
+	for _, data := range pemData {
+		require.True(t, pool.AppendCertsFromPEM(data))
+	}
+	return pool
+}
+
+func TestMergedTrustSources(t *testing.T) {
+	c := &ProxyClient{
+		namespace:      "test-namespace",
+		certSecretName: "ca",
+		caKey:          "ca.crt",
+		trustSources:   []string{"secret test-namespace/ca", "configmap test-namespace/extra-ca"},
+		clock:          clock.RealClock{},
+	}
+	onConfigMapChange := c.onConfigMapChange(configMapCASource{name: "extra-ca", key: "bundle.pem"})
+
+	secretCA := testCASecret(t, "secret-ca").Data[corev1.TLSCertKey]
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "ca"},
+		Data:       map[string][]byte{"ca.crt": secretCA},
+	}
+	_, err := c.onSecretChange("test-namespace/ca", secret)
+	require.NoError(t, err)
+	dialer, _ := c.currentDialer()
+	assert.Nil(t, dialer, "the client should wait for every source")
+
+	configMapCA := testCASecret(t, "configmap-ca").Data[corev1.TLSCertKey]
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "extra-ca"},
+		Data:       map[string]string{"bundle.pem": string(configMapCA) + string(secretCA)},
+	}
+	_, err = onConfigMapChange("test-namespace/extra-ca", configMap)
+	require.NoError(t, err)
+	dialer, caChanged := c.currentDialer()
+	require.NotNil(t, dialer)
+	assert.True(t, certPool(t, secretCA, configMapCA).Equal(dialer.TLSClientConfig.RootCAs))
+
+	// The CA the Secret shares with the ConfigMap stays trusted
+	_, err = c.onSecretChange("test-namespace/ca", &corev1.Secret{
+		ObjectMeta: secret.ObjectMeta,
+		Data:       map[string][]byte{"ca.crt": configMapCA},
+	})
+	require.NoError(t, err)
+	assert.False(t, isClosed(caChanged), "the merged bundle did not change")
+
+	_, err = onConfigMapChange("test-namespace/extra-ca", &corev1.ConfigMap{
+		ObjectMeta: configMap.ObjectMeta,
+		Data:       map[string]string{"bundle.pem": string(configMapCA)},
+	})
+	require.NoError(t, err)
+	assert.True(t, isClosed(caChanged))
+	dialer, _ = c.currentDialer()
+	assert.True(t, certPool(t, configMapCA).Equal(dialer.TLSClientConfig.RootCAs))
+
+	// Invalid data keeps the previous bundle
+	_, err = onConfigMapChange("test-namespace/extra-ca", &corev1.ConfigMap{
+		ObjectMeta: configMap.ObjectMeta,
+		Data:       map[string]string{"bundle.pem": "not a certificate"},
+	})
+	assert.ErrorContains(t, err, "failed to parse bundle.pem field of configmap test-namespace/extra-ca into a CA pool")
+	_, err = c.onSecretChange("test-namespace/ca", &corev1.Secret{ObjectMeta: secret.ObjectMeta})
+	assert.ErrorContains(t, err, "secret test-namespace/ca missing ca.crt field")
+	dialer, _ = c.currentDialer()
+	assert.NotNil(t, dialer)
+
+	_, err = onConfigMapChange("test-namespace/extra-ca", nil)
+	require.NoError(t, err)
+	dialer, _ = c.currentDialer()
+	assert.Nil(t, dialer, "dialer should be cleared while a source is missing")
+}
+
+func TestCAFile(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	firstCA := testCASecret(t, "first").Data[corev1.TLSCertKey]
+	require.NoError(t, os.WriteFile(path, firstCA, 0o600))
+
+	clock := testingclock.NewFakeClock(time.Now())
+	c := &ProxyClient{
+		namespace: "test-namespace",
+		caFiles:   []string{path},
+		clock:     clock,
+	}
+	c.watchTrustSources(ctx, false, nil)
+
+	var caChanged <-chan struct{}
+	require.Eventually(t, func() bool {
+		var dialer *websocket.Dialer
+		dialer, caChanged = c.currentDialer()
+		return dialer != nil
+	}, time.Second, time.Millisecond, "CA file was not loaded")
+
+	secondCA := testCASecret(t, "second").Data[corev1.TLSCertKey]
+	require.NoError(t, os.WriteFile(path, secondCA, 0o600))
+	require.Eventually(t, clock.HasWaiters, time.Second, time.Millisecond)
+	clock.Step(certificateWatchInterval)
+	require.Eventually(t, func() bool { return isClosed(caChanged) }, time.Second, time.Millisecond, "CA file was not reloaded")
+	dialer, _ := c.currentDialer()
+	assert.True(t, certPool(t, secondCA).Equal(dialer.TLSClientConfig.RootCAs))
+
+	require.NoError(t, os.Remove(path))
+	require.Eventually(t, clock.HasWaiters, time.Second, time.Millisecond)
+	clock.Step(certificateWatchInterval)
+	require.Eventually(t, func() bool {
+		dialer, _ := c.currentDialer()
+		return dialer == nil
+	}, time.Second, time.Millisecond, "dialer should be cleared once the file is removed")
+}